@@ -2,12 +2,31 @@ package main
 
 import (
 	alc "FluteTest/pkg/alc"
+	fdt "FluteTest/pkg/fdt"
+	fec "FluteTest/pkg/fec"
+	lct "FluteTest/pkg/lct"
+	oti "FluteTest/pkg/oti"
+	ep "FluteTest/pkg/udpendpoint"
 	utils "FluteTest/pkg/utils"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
 	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
 	"gopkg.in/yaml.v3"
 )
 
@@ -31,45 +50,493 @@ type receiverStaticARP struct {
 type receiverNetwork struct {
 	ListenIP string `yaml:"listen_ip"`
 	Port     int    `yaml:"port"`
+
+	// 设置后加入该组播组接收，不再使用单播地址
+	MulticastGroup string `yaml:"multicast_group"`
+	Interface      string `yaml:"interface"` // 加入组播组的接口，空表示由系统选择
+
+	// 会话过滤（RFC 6726 以 (源地址, TSI) 标识会话），为空表示不限。
+	// 组播时 source_ips 同时用于以 SSM (S,G) 方式加入组播组
+	SourceIPs []string `yaml:"source_ips"`
+	TSI       []uint64 `yaml:"tsi"`
 }
 
 type storage struct {
-	SaveDir string `yaml:"save_dir"`
+	SaveDir       string `yaml:"save_dir"`
+	QuarantineDir string `yaml:"quarantine_dir"` // 校验失败或不完整的对象
+
+	// 所有对象同时解码中的源块可占用的内存（MB），0 表示默认值 256。
+	// 收到的数据直接写入保存目录下的临时文件，内存占用与文件大小无关
+	MemoryBudgetMB uint64 `yaml:"memory_budget_mb"`
+
+	// 跳过保存前按 FDT 中的长度与摘要校验文件（需要重新读取整个文件）
+	SkipVerify bool `yaml:"skip_verify"`
+}
+
+// receiverTimeouts 控制未完成对象与空闲会话的淘汰。超时为 0 时使用默认值，小于 0 表示不超时
+type receiverTimeouts struct {
+	ObjectIdleS  int `yaml:"object_idle_s"`  // 对象连续这么久（秒）没有收到数据包即淘汰，默认 60
+	SessionIdleS int `yaml:"session_idle_s"` // 会话连续这么久（秒）没有收到数据包即结束，默认 300
+	MaxObjects   int `yaml:"max_objects"`    // 所有会话同时接收中的对象数上限，默认 1024，小于 0 表示不限
 }
+
 type receiverAppConfig struct {
 	StaticARP receiverStaticARP `yaml:"static_arp"`
 	Network   receiverNetwork   `yaml:"network"`
 	Storage   storage           `yaml:"storage"`
+	Timeouts  receiverTimeouts  `yaml:"timeouts"`
 }
 
 type fileBuffer struct {
-	TOI         uint32
-	TotalChunks uint32
-	Chunks      map[uint32][]byte
-	FileName    string
-	ContentType string
-	closeObject bool
+	TOI           uint64
+	tsi           uint64 // 所属会话的 TSI，用于生成隔离文件名
+	TotalChunks   uint32 // 源块数 Z
+	FileName      string // 经过校验与规范化的相对路径（以 "/" 分隔）
+	ContentType   string
+	path          string // 保存目录中的最终路径，已确认位于保存目录内
+	contentMD5    string // FDT 中的 Content-MD5（base64），为空时不校验
+	contentSHA256 string // FDT 中的 SHA-256 摘要（base64），为空时不校验
+	scheme        fec.FECScheme
+	decoders      map[uint32]fec.Decoder // 按源块编号索引的解码器，收到修复符号后才创建
+	symbols       uint32                 // 已接收的编码符号数，重复的源符号不计
+	oti           oti.Oti                // 对象的 OTI，来自 FDT（TOI 0 来自 EXT_FTI）
+	blocks        []oti.SourceBlock      // 由 OTI 还原的源块划分
+	quarantined   bool                   // 是否已移入隔离目录
+	desc          fdt.File               // 创建对象时 FDT 中的文件描述
+
+	// 对象数据：数据对象写入预分配的临时文件，FDT 实例（TOI 0）保存在内存中
+	file *os.File
+	data []byte
+
+	blockDone   []bool        // 已恢复的源块
+	doneBlocks  uint32        // 已恢复的源块数
+	blockSource []uint32      // 各源块已写入的源符号数
+	firstSymbol []uint64      // 各源块第一个源符号在对象中的序号
+	received    []uint64      // 已写入的源符号位图，按源符号在对象中的序号
+	written     uint64        // 已写入的源符号数
+	budget      *memoryBudget // 源块表与解码器的内存预算，nil 表示不限
+	tables      uint64        // 源块表与位图占用的内存预算
+	lastActive  time.Time     // 最近一次收到该对象数据包的时间，用于淘汰空闲对象
+}
+
+// newFileBuffer 按对象的 OTI 选择 FEC 方案并还原源块划分。dir 非空时对象数据写入 dir 下
+// 预分配的临时文件，解码器占用的内存受 budget 限制；dir 为空时保存在内存中（用于 FDT 实例）
+func newFileBuffer(toi uint64, info oti.Oti, dir string, budget *memoryBudget) (*fileBuffer, error) {
+	scheme, ok := fec.Lookup(info.FECEncodingID)
+	if !ok {
+		return nil, fmt.Errorf("unsupported FEC encoding ID %d", info.FECEncodingID)
+	}
+	// OTI 来自网络，分配源块表之前检查其未超出方案的上限，并从内存预算中预留源块表与位图
+	if err := info.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid OTI for TOI %d: %w", toi, err)
+	}
+	symbols, count, err := info.Blocking()
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTI for TOI %d: %w", toi, err)
+	}
+	tables := count*blockTableEntrySize + (symbols+63)/64*8
+	if !budget.reserve(tables) {
+		return nil, fmt.Errorf("memory budget exhausted (%d of %d bytes in use), cannot allocate %d bytes of block tables for TOI %d",
+			budget.used, budget.limit, tables, toi)
+	}
+	blocks, err := info.Partition()
+	if err != nil {
+		budget.release(tables)
+		return nil, fmt.Errorf("invalid OTI for TOI %d: %w", toi, err)
+	}
+
+	fb := &fileBuffer{
+		TOI:         toi,
+		TotalChunks: uint32(len(blocks)),
+		scheme:      scheme,
+		decoders:    make(map[uint32]fec.Decoder),
+		oti:         info,
+		blocks:      blocks,
+		blockDone:   make([]bool, len(blocks)),
+		blockSource: make([]uint32, len(blocks)),
+		firstSymbol: make([]uint64, len(blocks)),
+		budget:      budget,
+		tables:      tables,
+	}
+	var total uint64
+	for i, sb := range blocks {
+		fb.firstSymbol[i] = total
+		total += uint64(sb.Symbols)
+	}
+	fb.received = make([]uint64, (total+63)/64)
+	if info.TransferLength == 0 {
+		// 零长度对象没有数据，创建时即已完成
+		for i := range fb.blockDone {
+			fb.blockDone[i] = true
+		}
+		fb.doneBlocks = fb.TotalChunks
+	}
+
+	if dir == "" {
+		fb.data = make([]byte, info.TransferLength)
+		return fb, nil
+	}
+	file, err := os.CreateTemp(dir, fmt.Sprintf("%s%s-toi%d-*%s", tempFilePrefix, tempFileOwner, toi, tempFileSuffix))
+	if err != nil {
+		fb.discard()
+		return nil, fmt.Errorf("create temp file for TOI %d: %w", toi, err)
+	}
+	fb.file = file
+	// CreateTemp 创建的文件只有属主可读写，改为普通文件的权限，重命名后即为最终文件的权限
+	if err := file.Chmod(0o644); err != nil {
+		fb.discard()
+		return nil, fmt.Errorf("chmod temp file for TOI %d: %w", toi, err)
+	}
+	if err := file.Truncate(int64(info.TransferLength)); err != nil {
+		fb.discard()
+		return nil, fmt.Errorf("preallocate %d bytes for TOI %d: %w", info.TransferLength, toi, err)
+	}
+	return fb, nil
+}
+
+// 接收中的对象写入保存目录下的隐藏临时文件，校验通过后原子重命名为最终文件名；
+// 接收端启动时删除异常退出遗留的临时文件
+const (
+	tempFilePrefix = ".flute-"
+	tempFileSuffix = ".tmp"
+)
+
+// tempFileOwner 标识创建临时文件的接收端进程（主机名@PID），写在前缀之后。多个接收端共享保存目录时，
+// 启动时只删除本机已退出的进程遗留的临时文件
+var tempFileOwner = func() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" || strings.ContainsAny(host, "@/\\") {
+		host = "localhost"
+	}
+	return fmt.Sprintf("%s@%d", host, os.Getpid())
+}()
+
+// staleTempFile 判断临时文件是否由本机已退出的接收端进程创建。其他主机（共享的网络文件系统）、
+// 仍在运行的进程创建的以及无法识别创建者的临时文件都不是遗留文件
+func staleTempFile(name string) bool {
+	// 主机名中可能有 "-"，先按 "@" 分出主机名，PID 到下一个 "-" 为止
+	host, rest, ok := strings.Cut(strings.TrimPrefix(name, tempFilePrefix), "@")
+	self, _, _ := strings.Cut(tempFileOwner, "@")
+	if !ok || host != self {
+		return false
+	}
+	pidText, _, ok := strings.Cut(rest, "-")
+	if !ok {
+		return false
+	}
+	pid, err := strconv.Atoi(pidText)
+	if err != nil || pid <= 0 {
+		return false
+	}
+	// 启动时本进程尚未创建临时文件，PID 与本进程相同的是此前使用同一 PID 的进程遗留的
+	return pid == os.Getpid() || !processAlive(pid)
+}
+
+// processAlive 判断本机上的进程是否仍在运行。Windows 上 FindProcess 只对存在的进程成功
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	defer p.Release()
+	if runtime.GOOS == "windows" {
+		return true
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// cleanTempFiles 删除 root 目录树中本机已退出的接收端进程遗留的临时文件
+func cleanTempFiles(root string) {
+	removed := 0
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.Type().IsRegular() && strings.HasPrefix(name, tempFilePrefix) && strings.HasSuffix(name, tempFileSuffix) &&
+			staleTempFile(name) {
+			if err := os.Remove(path); err != nil {
+				fmt.Printf("Failed to remove leftover temp file %s: %v\n", path, err)
+			} else {
+				removed++
+			}
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Failed to scan %s for leftover temp files: %v\n", root, err)
+	}
+	if removed > 0 {
+		fmt.Printf("Removed %d leftover temp files from %s\n", removed, root)
+	}
 }
 
-func newFileBuffer(toi uint32) *fileBuffer {
-	return &fileBuffer{
-		TOI:    toi,
-		Chunks: make(map[uint32][]byte),
+// memoryBudget 限制所有对象同时使用的解码器内存（每个解码器按源块大小 K×E 估算）、
+// 源块表与源符号位图占用的内存，以及等待 FDT 描述的数据包占用的内存（按载荷长度计算）
+type memoryBudget struct {
+	limit uint64
+	used  uint64
+}
+
+func (b *memoryBudget) reserve(n uint64) bool {
+	if b == nil {
+		return true
+	}
+	if b.used+n > b.limit {
+		return false
 	}
+	b.used += n
+	return true
 }
 
+func (b *memoryBudget) release(n uint64) {
+	if b != nil {
+		b.used -= n
+	}
+}
+
+// blockTableEntrySize 为 fileBuffer 中每个源块的表项大小：源块划分、blockDone、blockSource 与 firstSymbol
+const blockTableEntrySize = uint64(unsafe.Sizeof(oti.SourceBlock{})) + 1 + 4 + 8
+
+// 等待 FDT 描述的数据包总数上限，与内存预算一起限制暂存的数据包
+const maxPendingPackets = 65536
+
+// 解码器内存预算的默认值（MB）
+const defaultMemoryBudgetMB = 256
+
+// FDT 实例在内存中重组，长度上限
+const maxFDTSize = 16 << 20
+
+// 报告各会话状态的间隔
+const statusInterval = 10 * time.Second
+
+// 对象与会话超时以及同时接收的对象数的默认值
+const (
+	defaultObjectIdle  = 60 * time.Second
+	defaultSessionIdle = 300 * time.Second
+	defaultMaxObjects  = 1024
+)
+
+// 检查超时的间隔，没有数据包到达时接收循环也按此间隔醒来
+const sweepInterval = time.Second
+
+// receiveQueue 按会话（源地址, TSI）分发数据包，各会话的对象互不影响
 type receiveQueue struct {
-	order []uint32
-	files map[uint32]*fileBuffer
+	saveDir       string
+	quarantineDir string
+
+	sessions    map[sessionKey]*session
+	pendingPkts int // 所有会话暂存的数据包总数
+	budget      *memoryBudget
+	verify      bool // 保存前是否按 FDT 校验
+	lastStatus  time.Time
+
+	objectIdle  time.Duration // 对象空闲超时，0 表示不超时
+	sessionIdle time.Duration // 会话空闲超时，0 表示不超时
+	maxObjects  int           // 同时接收中的对象数上限，0 表示不限
+	lastSweep   time.Time
+}
+
+// sessionKey 为 RFC 6726 的会话标识：发送端源地址与 TSI
+type sessionKey struct {
+	source string
+	tsi    uint64
 }
 
-func newReceiveQueue() *receiveQueue {
+func (k sessionKey) String() string {
+	return fmt.Sprintf("(%s, TSI %d)", k.source, k.tsi)
+}
+
+// session 为一个 FLUTE 会话的接收状态：对象表、FDT 数据库与统计信息
+type session struct {
+	q   *receiveQueue
+	key sessionKey
+
+	order     []uint64
+	files     map[uint64]*fileBuffer
+	completed map[uint64]fdt.File // 已完成的对象及其文件描述，描述不变时丢弃该对象后续的数据包
+	rejected  map[uint64]bool     // 无法接收的对象（如文件名不安全），FDT 更新其描述前丢弃其数据包
+
+	fdtDB      *fdt.Database
+	fdtBuffers map[uint32]*fileBuffer  // 按实例号重组中的 FDT 实例（TOI 0）
+	pending    map[uint64][]pendingPkt // FDT 尚未描述的 TOI 的数据包
+	pendingLen uint64                  // 暂存数据包的载荷总字节数，计入内存预算
+
+	started     time.Time
+	lastActive  time.Time
+	packets     uint64 // 收到的数据包数
+	saved       int    // 校验通过并保存的文件数
+	quarantined int    // 校验失败或不完整而隔离的文件数
+	failed      int    // 因超时、FDT 失效或对象数上限而淘汰以及保存失败的对象数
+}
+
+type pendingPkt struct {
+	pkt  *alc.AlcPkt
+	addr *net.UDPAddr
+	at   time.Time // 收到的时间
+}
+
+func newReceiveQueue(saveDir, quarantineDir string, budget uint64) *receiveQueue {
 	return &receiveQueue{
-		order: make([]uint32, 0),
-		files: make(map[uint32]*fileBuffer),
+		saveDir:       saveDir,
+		quarantineDir: quarantineDir,
+		budget:        &memoryBudget{limit: budget},
+		sessions:      make(map[sessionKey]*session),
+		lastStatus:    time.Now(),
+	}
+}
+
+// session 返回数据包所属的会话，首次出现时创建
+func (q *receiveQueue) session(key sessionKey, now time.Time) *session {
+	s, ok := q.sessions[key]
+	if !ok {
+		s = &session{
+			q:          q,
+			key:        key,
+			order:      make([]uint64, 0),
+			files:      make(map[uint64]*fileBuffer),
+			completed:  make(map[uint64]fdt.File),
+			rejected:   make(map[uint64]bool),
+			fdtDB:      fdt.NewDatabase(),
+			fdtBuffers: make(map[uint32]*fileBuffer),
+			pending:    make(map[uint64][]pendingPkt),
+			started:    now,
+		}
+		q.sessions[key] = s
+		fmt.Printf("New session %v\n", key)
+	}
+	s.lastActive = now
+	s.packets++
+	return s
+}
+
+// closeSession 结束会话：保存已完成的对象，隔离不完整的对象并报告会话状态。会话不存在时返回 false
+func (q *receiveQueue) closeSession(key sessionKey) bool {
+	s, ok := q.sessions[key]
+	if !ok {
+		return false
+	}
+	s.flushAll()
+	delete(q.sessions, key)
+	fmt.Printf("Session %v closed: %s\n", key, s.status())
+	return true
+}
+
+// closeAll 结束所有会话
+func (q *receiveQueue) closeAll() {
+	for _, key := range q.sessionKeys() {
+		q.closeSession(key)
+	}
+}
+
+// expire 结束空闲超过 sessionIdle 的会话，并淘汰各会话中空闲或 FDT 描述已失效的对象，
+// 每 sweepInterval 最多执行一次
+func (q *receiveQueue) expire(now time.Time) {
+	if now.Sub(q.lastSweep) < sweepInterval {
+		return
+	}
+	q.lastSweep = now
+	for _, key := range q.sessionKeys() {
+		s := q.sessions[key]
+		if idle := now.Sub(s.lastActive); q.sessionIdle > 0 && idle >= q.sessionIdle {
+			fmt.Printf("Session %v idle for %v, closing\n", key, idle.Round(time.Second))
+			q.closeSession(key)
+			continue
+		}
+		s.expire(now)
+	}
+}
+
+// makeRoom 在接收中的对象数达到 maxObjects 时淘汰所有会话中最久未收到数据包的未完成对象
+func (q *receiveQueue) makeRoom() {
+	if q.maxObjects <= 0 {
+		return
+	}
+	for {
+		count := 0
+		for _, s := range q.sessions {
+			count += len(s.files)
+		}
+		if count < q.maxObjects {
+			return
+		}
+
+		var victim *session
+		var victimTOI uint64
+		var oldest time.Time
+		for _, s := range q.sessions {
+			for toi, fb := range s.files {
+				if !fb.isComplete() && (victim == nil || fb.lastActive.Before(oldest)) {
+					victim, victimTOI, oldest = s, toi, fb.lastActive
+				}
+			}
+		}
+		if victim == nil {
+			return
+		}
+		victim.evict(victimTOI, fmt.Sprintf("%d objects in progress, max_objects is %d", count, q.maxObjects))
+		victim.flushReady()
 	}
 }
 
+// securityEvent 记录因文件名不安全而拒绝的对象：打印告警并追加到隔离目录的 security.log
+func (q *receiveQueue) securityEvent(key sessionKey, toi uint64, name string, reason error) {
+	fmt.Printf("SECURITY: session %v TOI %d: rejected file name %q: %v\n", key, toi, name, reason)
+
+	if err := os.MkdirAll(q.quarantineDir, 0o755); err != nil {
+		fmt.Printf("Cannot record security event: %v\n", err)
+		return
+	}
+	logPath := filepath.Join(q.quarantineDir, "security.log")
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		fmt.Printf("Cannot record security event: %v\n", err)
+		return
+	}
+	defer logFile.Close()
+	if _, err := fmt.Fprintf(logFile, "%s source=%s TSI=%d TOI=%d name=%q: %v\n",
+		time.Now().Format(time.RFC3339), key.source, key.tsi, toi, name, reason); err != nil {
+		fmt.Printf("Cannot record security event: %v\n", err)
+	}
+}
+
+// reportStatus 每隔 statusInterval 打印一次各会话的状态
+func (q *receiveQueue) reportStatus(now time.Time) {
+	if now.Sub(q.lastStatus) < statusInterval {
+		return
+	}
+	q.lastStatus = now
+	for _, key := range q.sessionKeys() {
+		fmt.Printf("Session %v: %s\n", key, q.sessions[key].status())
+	}
+}
+
+// sessionKeys 返回按源地址与 TSI 排序的会话标识
+func (q *receiveQueue) sessionKeys() []sessionKey {
+	keys := make([]sessionKey, 0, len(q.sessions))
+	for key := range q.sessions {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].source != keys[j].source {
+			return keys[i].source < keys[j].source
+		}
+		return keys[i].tsi < keys[j].tsi
+	})
+	return keys
+}
+
+// status 返回会话的状态摘要
+func (s *session) status() string {
+	pending := 0
+	for _, pkts := range s.pending {
+		pending += len(pkts)
+	}
+	return fmt.Sprintf("%d packets in %v, %d files saved, %d quarantined, %d failed, %d in progress, %d packets awaiting FDT",
+		s.packets, s.lastActive.Sub(s.started).Round(time.Millisecond), s.saved, s.quarantined, s.failed, len(s.files), pending)
+}
+
 func loadReceiverConfig(path string) (*receiverAppConfig, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -94,33 +561,78 @@ func main() {
 		fmt.Printf("static ARP setup failed: %v\n", err)
 	}
 
-	// Setup UDP listener
-	listenAddr := &net.UDPAddr{
-		IP:   net.ParseIP(cfg.StaticARP.PeerIP),
-		Port: cfg.Network.Port,
+	// Setup UDP listener，配置了组播组时在指定接口上加入该组
+	endpoint := ep.Endpoint{
+		DestAddr:  cfg.Network.ListenIP,
+		Port:      cfg.Network.Port,
+		Interface: cfg.Network.Interface,
 	}
-	listen, err := net.ListenUDP("udp", listenAddr)
+	filter, err := newSessionFilter(cfg.Network.SourceIPs, cfg.Network.TSI)
+	if err != nil {
+		fmt.Printf("Invalid session filter: %v\n", err)
+		return
+	}
+	if cfg.Network.MulticastGroup != "" {
+		endpoint.DestAddr = cfg.Network.MulticastGroup
+		endpoint.Sources = cfg.Network.SourceIPs
+		fmt.Printf("Joining multicast group %s:%d on interface %q (sources %v)\n", endpoint.DestAddr, endpoint.Port,
+			endpoint.Interface, endpoint.Sources)
+	}
+	listen, err := endpoint.Listen()
 	if err != nil {
 		fmt.Println("Listen failed:", err)
 		return
 	}
 	defer listen.Close()
 
+	if cfg.Storage.QuarantineDir == "" {
+		cfg.Storage.QuarantineDir = "./cmd/quarantine_files"
+	}
+
 	// Prepare file storage
 	if err := os.MkdirAll(cfg.Storage.SaveDir, 0755); err != nil {
 		fmt.Printf("Failed to create directory: %v\n", err)
 		return
 	}
+	cleanTempFiles(cfg.Storage.SaveDir)
+	cleanTempFiles(cfg.Storage.QuarantineDir)
 
-	queue := newReceiveQueue()
+	if cfg.Storage.MemoryBudgetMB == 0 {
+		cfg.Storage.MemoryBudgetMB = defaultMemoryBudgetMB
+	}
+	queue := newReceiveQueue(cfg.Storage.SaveDir, cfg.Storage.QuarantineDir, cfg.Storage.MemoryBudgetMB<<20)
+	queue.verify = !cfg.Storage.SkipVerify
+	queue.objectIdle = timeoutSetting(cfg.Timeouts.ObjectIdleS, defaultObjectIdle)
+	queue.sessionIdle = timeoutSetting(cfg.Timeouts.SessionIdleS, defaultSessionIdle)
+	switch {
+	case cfg.Timeouts.MaxObjects == 0:
+		queue.maxObjects = defaultMaxObjects
+	case cfg.Timeouts.MaxObjects > 0:
+		queue.maxObjects = cfg.Timeouts.MaxObjects
+	}
+	fmt.Printf("Object idle timeout %v, session idle timeout %v, max objects %d (0 = unlimited)\n",
+		queue.objectIdle, queue.sessionIdle, queue.maxObjects)
 	buf := make([]byte, 65507) // Max UDP packet size
 
 	for {
+		// 设置读超时，没有数据包时也能定期淘汰空闲的对象与会话
+		listen.SetReadDeadline(time.Now().Add(sweepInterval))
 		n, addr, err := listen.ReadFromUDP(buf)
 		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				now := time.Now()
+				queue.expire(now)
+				queue.reportStatus(now)
+				continue
+			}
 			fmt.Println("Read error:", err)
 			continue
 		}
+		if !filter.acceptSource(addr.IP) {
+			fmt.Printf("Drop packet from %v: source not in session\n", addr)
+			continue
+		}
 
 		// Parse ALC packet
 		pkt, err := alc.ParseAlcPkt(buf[:n])
@@ -128,11 +640,19 @@ func main() {
 			fmt.Printf("Parse error: %v\n", err)
 			continue
 		}
+		if !filter.acceptTSI(pkt.LCTHeader.TSI) {
+			fmt.Printf("Drop packet from %v: TSI %d not in session\n", addr, pkt.LCTHeader.TSI)
+			continue
+		}
 
+		key := sessionKey{source: addr.IP.String(), tsi: pkt.LCTHeader.TSI}
 		if pkt.LCTHeader.CloseSession {
-			fmt.Println("Received close session packet")
-			queue.flushAll(cfg.Storage.SaveDir)
-			break
+			fmt.Printf("Received close session packet for session %v\n", key)
+			queue.closeSession(key)
+			if len(queue.sessions) == 0 {
+				break
+			}
+			continue
 		}
 
 		if len(pkt.EncodingSymbols) == 0 {
@@ -140,171 +660,837 @@ func main() {
 			continue
 		}
 
-		fb := queue.getOrCreate(pkt)
+		now := time.Now()
+		sess := queue.session(key, now)
+		if pkt.LCTHeader.TOI == 0 {
+			sess.handleFDT(pkt, addr)
+		} else {
+			sess.handleDataPkt(pkt, addr)
+		}
+		queue.expire(now)
+		queue.reportStatus(now)
+	}
+
+	queue.closeAll()
+}
+
+// timeoutSetting 将配置的秒数转换为超时：0 使用默认值，小于 0 表示不超时（返回 0）
+func timeoutSetting(seconds int, def time.Duration) time.Duration {
+	switch {
+	case seconds == 0:
+		return def
+	case seconds < 0:
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
 
-		stored, storedLen, err := fb.storeChunk(pkt)
+// sessionFilter 按会话标识（源地址, TSI）过滤数据包，列表为空表示不限
+type sessionFilter struct {
+	sources []net.IP
+	tsis    map[uint64]bool
+}
+
+func newSessionFilter(sources []string, tsis []uint64) (*sessionFilter, error) {
+	f := &sessionFilter{}
+	for _, s := range sources {
+		ip, _, err := ep.ParseIP(s)
 		if err != nil {
-			fmt.Printf("Failed to store chunk: %v\n", err)
-			continue
+			return nil, fmt.Errorf("invalid source IP: %w", err)
 		}
-		if !stored {
-			fmt.Printf("Duplicate chunk %d for TOI %d from %v, ignoring\n", pkt.SourceBlockNb, pkt.LCTHeader.TOI, addr)
-			queue.flushReady(cfg.Storage.SaveDir)
-			continue
+		f.sources = append(f.sources, ip)
+	}
+	if len(tsis) > 0 {
+		f.tsis = make(map[uint64]bool, len(tsis))
+		for _, tsi := range tsis {
+			f.tsis[tsi] = true
 		}
+	}
+	return f, nil
+}
 
-		totalChunks := fb.TotalChunks
-		if totalChunks == 0 {
-			fmt.Printf("Received chunk %d for TOI %d from %v (stored=%d bytes, total unknown)\n", pkt.SourceBlockNb+1, pkt.LCTHeader.TOI, addr, storedLen)
-		} else {
-			fmt.Printf("Received chunk %d/%d for TOI %d from %v (stored=%d bytes)\n", pkt.SourceBlockNb+1, totalChunks, pkt.LCTHeader.TOI, addr, storedLen)
+func (f *sessionFilter) acceptSource(ip net.IP) bool {
+	if len(f.sources) == 0 {
+		return true
+	}
+	for _, source := range f.sources {
+		if source.Equal(ip) {
+			return true
 		}
-		fmt.Printf("CloseObject: %v, chunks stored: %d/%d\n", pkt.LCTHeader.CloseObject, len(fb.Chunks), fb.TotalChunks)
+	}
+	return false
+}
 
-		queue.flushReady(cfg.Storage.SaveDir)
+func (f *sessionFilter) acceptTSI(tsi uint64) bool {
+	return f.tsis == nil || f.tsis[tsi]
+}
+
+// handleFDT 按 EXT_FDT 中的实例号重组 TOI 0 对象，完整后解析为 FDT 实例加入数据库，
+// 并处理此前因缺少文件描述而暂存的数据包
+func (s *session) handleFDT(pkt *alc.AlcPkt, addr *net.UDPAddr) {
+	ext, ok := pkt.FindExtension(lct.ExtFDT)
+	if !ok {
+		fmt.Printf("TOI 0 packet without EXT_FDT from %v, ignoring\n", addr)
+		return
+	}
+	instanceID := ext.(lct.FDTExt).InstanceID
+	tsi := pkt.LCTHeader.TSI
+	now := time.Now()
+	if s.fdtDB.Has(tsi, instanceID, now) {
+		return
+	}
+	if !pkt.WithFTI {
+		fmt.Printf("FDT instance %d packet without EXT_FTI, ignoring\n", instanceID)
+		return
 	}
 
+	fb, ok := s.fdtBuffers[instanceID]
+	if !ok {
+		if pkt.OTI.TransferLength > maxFDTSize {
+			fmt.Printf("FDT instance %d is %d bytes, exceeds limit %d, ignoring\n", instanceID, pkt.OTI.TransferLength, maxFDTSize)
+			return
+		}
+		var err error
+		if fb, err = newFileBuffer(0, pkt.OTI, "", nil); err != nil {
+			fmt.Printf("Cannot receive FDT instance %d: %v\n", instanceID, err)
+			return
+		}
+		s.fdtBuffers[instanceID] = fb
+	}
+	fb.lastActive = s.lastActive
+	if _, err := fb.storeSymbol(pkt); err != nil {
+		fmt.Printf("Failed to store FDT symbol: %v\n", err)
+		return
+	}
+	if !fb.isComplete() {
+		return
+	}
+	delete(s.fdtBuffers, instanceID)
+
+	data, err := fb.reconstruct()
+	if err != nil {
+		fmt.Printf("Failed to reassemble FDT instance %d: %v\n", instanceID, err)
+		return
+	}
+	instance, err := fdt.Parse(data)
+	if err != nil {
+		fmt.Printf("Invalid FDT instance %d: %v\n", instanceID, err)
+		return
+	}
+	instance.InstanceID = instanceID
+
+	s.fdtDB.Expire(now)
+	updated, err := s.fdtDB.Add(tsi, instance, now)
+	if err != nil {
+		fmt.Printf("Rejected FDT instance %d: %v\n", instanceID, err)
+	}
+	fmt.Printf("FDT instance %d received for session %v: %d files, %d new or updated\n", instanceID, s.key, len(instance.Files), len(updated))
+
+	for _, toi := range updated {
+		delete(s.rejected, toi)
+		file, _, ok := s.fdtDB.Lookup(tsi, toi, now)
+		if done, completed := s.completed[toi]; ok && completed && !file.Equal(done) {
+			// 描述变化的已完成对象视为新的对象
+			delete(s.completed, toi)
+		}
+		if _, completed := s.completed[toi]; ok && !completed && s.files[toi] == nil {
+			// 目录与零长度文件没有数据包，收到描述即创建；FDT 实例更新时描述不变的不再重新创建
+			if strings.HasSuffix(file.ContentLocation, "/") {
+				s.createDirectory(toi, file)
+				continue
+			}
+			if info, ok, err := file.OTI(); err == nil && ok && info.TransferLength == 0 {
+				if _, err := s.create(toi, file); err != nil {
+					fmt.Printf("Cannot receive TOI %d: %v\n", toi, err)
+					s.rejected[toi] = true
+				}
+				continue
+			}
+		}
+		for _, p := range s.dropPending(toi) {
+			s.handleDataPkt(p.pkt, p.addr)
+		}
+	}
+	s.flushReady()
 }
 
-func (q *receiveQueue) getOrCreate(pkt *alc.AlcPkt) *fileBuffer {
+// handleDataPkt 处理数据对象的数据包。对象首次出现时从 FDT 数据库解析其文件描述，
+// FDT 尚未描述该 TOI 时暂存数据包
+func (s *session) handleDataPkt(pkt *alc.AlcPkt, addr *net.UDPAddr) {
+	if s.isCompleted(pkt) {
+		return
+	}
+
 	toi := pkt.LCTHeader.TOI
-	if fb, ok := q.files[toi]; ok {
-		fb.applyMetadata(pkt)
-		return fb
+	if s.rejected[toi] {
+		return
 	}
+	fb, ok := s.files[toi]
+	if !ok {
+		file, instanceID, found := s.fdtDB.Lookup(pkt.LCTHeader.TSI, toi, time.Now())
+		if !found {
+			s.addPending(toi, pendingPkt{pkt: pkt, addr: addr, at: s.lastActive})
+			return
+		}
 
-	fb := newFileBuffer(toi)
-	q.files[toi] = fb
-	q.order = append(q.order, toi)
-	fb.applyMetadata(pkt)
-	return fb
+		s.q.makeRoom()
+		var err error
+		fb, err = s.create(toi, file)
+		if err != nil {
+			fmt.Printf("Cannot receive TOI %d: %v\n", toi, err)
+			s.rejected[toi] = true
+			return
+		}
+		fmt.Printf("TOI %d described by FDT instance %d: %s (%d bytes, %s, %s)\n",
+			toi, instanceID, fb.FileName, fb.oti.TransferLength, fb.ContentType, fb.scheme.Name())
+	}
+
+	if pkt.OTI.FECEncodingID != fb.scheme.EncodingID() {
+		fmt.Printf("Packet for TOI %d uses FEC encoding %d, FDT says %d, ignoring\n", toi, pkt.OTI.FECEncodingID, fb.scheme.EncodingID())
+		return
+	}
+
+	fb.lastActive = s.lastActive
+	decoded, err := fb.storeSymbol(pkt)
+	if err != nil {
+		fmt.Printf("Failed to store symbol from %v: %v\n", addr, err)
+		return
+	}
+	if decoded {
+		fmt.Printf("Source block %d of TOI %d decoded (%d/%d blocks, %d symbols received)\n",
+			pkt.SourceBlockNb, toi, fb.doneBlocks, fb.TotalChunks, fb.symbols)
+	}
+	s.flushReady()
 }
 
-func (q *receiveQueue) flushReady(saveDir string) {
-	for len(q.order) > 0 {
-		toi := q.order[0]
-		fb := q.files[toi]
+// create 根据 FDT 中的文件描述为数据对象创建缓冲区
+func (s *session) create(toi uint64, file fdt.File) (*fileBuffer, error) {
+	info, ok, err := file.OTI()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("FDT entry has no FEC-OTI-FEC-Encoding-ID")
+	}
+
+	name := file.ContentLocation
+	if name == "" {
+		name = fmt.Sprintf("toi_%d.bin", toi)
+	}
+	rel, err := safeFileName(name)
+	if err != nil {
+		s.q.securityEvent(s.key, toi, name, err)
+		return nil, fmt.Errorf("unsafe file name %q: %w", name, err)
+	}
+	dest, err := utils.ResolveInRoot(s.q.saveDir, rel)
+	if err != nil {
+		s.q.securityEvent(s.key, toi, name, err)
+		return nil, fmt.Errorf("cannot place %q under %s: %w", name, s.q.saveDir, err)
+	}
+
+	// 临时文件建在最终路径所在目录，保证原子重命名不跨文件系统
+	fb, err := newFileBuffer(toi, info, filepath.Dir(dest), s.q.budget)
+	if err != nil {
+		return nil, err
+	}
+	fb.FileName = rel
+	fb.path = dest
+	fb.tsi = s.key.tsi
+	fb.lastActive = s.lastActive
+	fb.ContentType = file.ContentType
+	fb.contentMD5 = file.ContentMD5
+	fb.contentSHA256 = file.ContentSHA256
+	fb.desc = file
+
+	s.files[toi] = fb
+	s.order = append(s.order, toi)
+	return fb, nil
+}
+
+// createDirectory 在保存目录下创建 FDT 中以 "/" 结尾的 Content-Location 描述的目录（用于传输空目录）
+func (s *session) createDirectory(toi uint64, file fdt.File) {
+	name := file.ContentLocation
+	rel, err := utils.SanitizeRelativePath(name)
+	if err != nil {
+		s.q.securityEvent(s.key, toi, name, err)
+		s.rejected[toi] = true
+		return
+	}
+	dir, err := utils.MkdirInRoot(s.q.saveDir, rel)
+	if err != nil {
+		s.q.securityEvent(s.key, toi, name, err)
+		s.rejected[toi] = true
+		return
+	}
+	s.completed[toi] = file
+	fmt.Printf("Directory (TOI=%d) created: %s\n", toi, dir)
+}
+
+// flushReady 保存所有已完整的对象并将其移出 s.order，不等待排在前面的未完成对象
+func (s *session) flushReady() {
+	pending := s.order[:0]
+	for _, toi := range s.order {
+		fb := s.files[toi]
 		if fb == nil {
-			q.order = q.order[1:]
 			continue
 		}
-
 		if !fb.isComplete() {
-			break
+			pending = append(pending, toi)
+			continue
 		}
 
-		if err := fb.save(saveDir); err != nil {
+		delete(s.files, toi)
+		// 保存失败的对象不记为已完成，轮播的后续轮次可重新接收
+		err := fb.save(s.q.quarantineDir, s.q.verify)
+		if fb.quarantined {
+			s.quarantined++
+		} else if err == nil {
+			s.saved++
+		} else {
+			s.failed++
+		}
+		if err != nil {
 			fmt.Printf("Failed to finalize file (TOI=%d): %v\n", fb.TOI, err)
+			continue
+		}
+		s.completed[toi] = fb.desc
+	}
+	s.order = pending
+}
+
+func (s *session) flushAll() {
+	s.flushReady()
+
+	for len(s.order) > 0 {
+		toi := s.order[0]
+		if fb := s.files[toi]; fb == nil || fb.symbols == 0 {
+			if fb != nil {
+				fb.discard()
+			}
+			delete(s.files, toi)
+			s.order = s.order[1:]
+			continue
 		}
+		s.evict(toi, "session closed")
+	}
 
-		delete(q.files, toi)
-		q.order = q.order[1:]
+	for toi := range s.pending {
+		fmt.Printf("TOI %d never described by an FDT instance: %d packets dropped\n", toi, len(s.dropPending(toi)))
 	}
 }
 
-func (q *receiveQueue) flushAll(saveDir string) {
-	q.flushReady(saveDir)
+// addPending 暂存 FDT 尚未描述的 TOI 的数据包，载荷计入内存预算。数据包总数或预算达到上限时，
+// 若有其他会话暂存的数据比本会话多，丢弃其中暂存数据最多的会话的全部暂存数据包后重试，
+// 否则丢弃新到的数据包，单个会话无法长期占满预算
+func (s *session) addPending(toi uint64, p pendingPkt) {
+	size := uint64(len(p.pkt.EncodingSymbols))
+	for s.q.pendingPkts >= maxPendingPackets || !s.q.budget.reserve(size) {
+		var victim *session
+		for _, other := range s.q.sessions {
+			if other.pendingLen > s.pendingLen && (victim == nil || other.pendingLen > victim.pendingLen) {
+				victim = other
+			}
+		}
+		if victim == nil {
+			fmt.Printf("Pending packet limit reached (%d packets, %d of %d bytes in use), dropping packet for TOI %d\n",
+				s.q.pendingPkts, s.q.budget.used, s.q.budget.limit, toi)
+			return
+		}
+		dropped := 0
+		for t := range victim.pending {
+			dropped += len(victim.dropPending(t))
+		}
+		fmt.Printf("Pending packet limit reached, dropped %d packets awaiting FDT from session %v\n", dropped, victim.key)
+	}
+	s.pending[toi] = append(s.pending[toi], p)
+	s.pendingLen += size
+	s.q.pendingPkts++
+}
+
+// dropPending 取出 TOI 暂存的数据包并释放其内存预算
+func (s *session) dropPending(toi uint64) []pendingPkt {
+	pending := s.pending[toi]
+	delete(s.pending, toi)
+	for _, p := range pending {
+		size := uint64(len(p.pkt.EncodingSymbols))
+		s.pendingLen -= size
+		s.q.budget.release(size)
+	}
+	s.q.pendingPkts -= len(pending)
+	return pending
+}
 
-	for len(q.order) > 0 {
-		toi := q.order[0]
-		fb := q.files[toi]
-		if fb != nil && len(fb.Chunks) > 0 {
-			fmt.Printf("File (TOI=%d) incomplete: received %d/%d chunks\n", fb.TOI, len(fb.Chunks), fb.TotalChunks)
+// evict 淘汰未完成的对象：报告收到的符号数，已写入数据的对象移入隔离目录（缺失部分为零）
+func (s *session) evict(toi uint64, reason string) {
+	if fb := s.files[toi]; fb != nil {
+		err := fmt.Errorf("object failed: received %d of %d symbols (%d/%d source blocks decoded): %s",
+			fb.symbols, fb.sourceSymbols(), fb.doneBlocks, fb.TotalChunks, reason)
+		fmt.Printf("File (TOI=%d) %s %v\n", toi, fb.fileName(), err)
+		if fb.written > 0 {
+			if qerr := fb.quarantine(s.q.quarantineDir, err); qerr != nil {
+				fmt.Printf("Failed to quarantine file (TOI=%d): %v\n", toi, qerr)
+			}
+			if fb.quarantined {
+				s.quarantined++
+			}
+		}
+		fb.discard()
+		delete(s.files, toi)
+		s.failed++
+	}
+	for i, t := range s.order {
+		if t == toi {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
 		}
-		delete(q.files, toi)
-		q.order = q.order[1:]
 	}
 }
 
-func (fb *fileBuffer) applyMetadata(pkt *alc.AlcPkt) {
-	if pkt.TotalChunks > fb.TotalChunks {
-		fb.TotalChunks = pkt.TotalChunks
+// expire 淘汰空闲超过 objectIdle 或 FDT 描述已失效的未完成对象，丢弃长时间未完成的 FDT 实例与
+// 等待 FDT 的数据包，并清除 FDT 描述已失效的 TOI 的完成与拒绝标记
+func (s *session) expire(now time.Time) {
+	s.fdtDB.Expire(now)
+	tsi := s.key.tsi
+	idle := s.q.objectIdle
+	described := func(toi uint64) bool {
+		_, _, ok := s.fdtDB.Lookup(tsi, toi, now)
+		return ok
 	}
-	if fb.FileName == "" && pkt.FDT.FileName != "" {
-		fb.FileName = pkt.FDT.FileName
+
+	for _, toi := range append([]uint64(nil), s.order...) {
+		fb := s.files[toi]
+		if fb == nil || fb.isComplete() {
+			continue
+		}
+		if !described(toi) {
+			s.evict(toi, "file description expired (FDT Expires)")
+		} else if idle > 0 && now.Sub(fb.lastActive) >= idle {
+			s.evict(toi, fmt.Sprintf("no packets for %v", now.Sub(fb.lastActive).Round(time.Second)))
+		}
 	}
-	if fb.ContentType == "" && pkt.FDT.ContentType != "" {
-		fb.ContentType = pkt.FDT.ContentType
+	s.flushReady()
+
+	if idle > 0 {
+		for instanceID, fb := range s.fdtBuffers {
+			if now.Sub(fb.lastActive) >= idle {
+				fmt.Printf("FDT instance %d of session %v failed: received %d of %d symbols, dropped\n",
+					instanceID, s.key, fb.symbols, fb.sourceSymbols())
+				delete(s.fdtBuffers, instanceID)
+			}
+		}
+		for toi, pending := range s.pending {
+			if now.Sub(pending[len(pending)-1].at) >= idle {
+				fmt.Printf("TOI %d not described by any FDT instance for %v: %d packets dropped\n",
+					toi, idle, len(s.dropPending(toi)))
+			}
+		}
 	}
-	if pkt.LCTHeader.CloseObject {
-		fb.closeObject = true
+
+	for toi := range s.completed {
+		if !described(toi) {
+			delete(s.completed, toi)
+		}
 	}
+	for toi := range s.rejected {
+		if !described(toi) {
+			delete(s.rejected, toi)
+		}
+	}
+}
+
+// isCompleted 判断数据包是否属于已完成的对象，用于丢弃轮播中重复发送的符号。
+// 只要 FDT 中该 TOI 的文件描述不变（实例续期不影响），对象就不会重新接收；
+// 描述变化（如内容、长度或摘要改变）时视为新的对象，清除完成标记
+func (s *session) isCompleted(pkt *alc.AlcPkt) bool {
+	toi := pkt.LCTHeader.TOI
+	done, ok := s.completed[toi]
+	if !ok {
+		return false
+	}
+	if file, _, found := s.fdtDB.Lookup(pkt.LCTHeader.TSI, toi, time.Now()); found && !file.Equal(done) {
+		delete(s.completed, toi)
+		return false
+	}
+	return true
 }
 
-func (fb *fileBuffer) storeChunk(pkt *alc.AlcPkt) (bool, int, error) {
-	chunkIndex := pkt.SourceBlockNb
-	if _, exists := fb.Chunks[chunkIndex]; exists {
-		fb.applyMetadata(pkt)
-		return false, 0, nil
+// storeSymbol 保存一个编码符号。源符号直接写入对象中的对应位置并记入位图，
+// 源块的源符号全部收到即完成；收到修复符号后才为源块创建解码器，恢复后写入整个源块并释放解码器
+func (fb *fileBuffer) storeSymbol(pkt *alc.AlcPkt) (bool, error) {
+	sbn, esi := pkt.SourceBlockNb, pkt.EncodingSymbol
+	if int(sbn) >= len(fb.blocks) {
+		return false, fmt.Errorf("source block %d out of range (Z=%d) for TOI %d", sbn, len(fb.blocks), fb.TOI)
+	}
+	if fb.blockDone[sbn] {
+		return false, nil
+	}
+	sb := fb.blocks[sbn]
+	symbol := pkt.EncodingSymbols
+
+	start, end, source := fec.SourceSymbolRange(fb.oti, sb, esi)
+	if source {
+		index := fb.firstSymbol[sbn] + uint64(esi)
+		if fb.hasSymbol(index) {
+			return false, nil
+		}
+		if uint64(len(symbol)) < end-start || len(symbol) > int(fb.oti.EncodingSymbolLength) {
+			return false, fmt.Errorf("incorrect symbol size %d for ESI %d of block %d of TOI %d", len(symbol), esi, sbn, fb.TOI)
+		}
+		fb.symbols++
+		if err := fb.writeAt(symbol[:end-start], sb.Offset+start); err != nil {
+			return false, fmt.Errorf("write block %d of TOI %d: %w", sbn, fb.TOI, err)
+		}
+		fb.setSymbol(index)
+		fb.blockSource[sbn]++
+		if fb.blockSource[sbn] == sb.Symbols {
+			fb.finishBlock(sbn)
+			return true, nil
+		}
+		if _, ok := fb.decoders[sbn]; !ok {
+			return false, nil
+		}
 	}
 
-	payloadLen := pkt.PayloadLength
-	if payloadLen == 0 || payloadLen > uint32(len(pkt.EncodingSymbols)) {
-		payloadLen = uint32(len(pkt.EncodingSymbols))
+	decoder, err := fb.decoder(sbn)
+	if err != nil {
+		return false, err
+	}
+	done, err := decoder.AddSymbol(esi, symbol)
+	if err != nil {
+		return false, fmt.Errorf("block %d of TOI %d: %w", sbn, fb.TOI, err)
+	}
+	if !source {
+		// 修复符号没有位图，重复的修复符号交给解码器忽略，这里按解码器接受的次数计数
+		fb.symbols++
 	}
-	if payloadLen == 0 {
-		return false, 0, fmt.Errorf("empty payload for chunk %d", chunkIndex)
+	if !done {
+		return false, nil
 	}
 
-	dataToStore := make([]byte, payloadLen)
-	copy(dataToStore, pkt.EncodingSymbols[:payloadLen])
-	fb.Chunks[chunkIndex] = dataToStore
-	fb.applyMetadata(pkt)
-	return true, len(dataToStore), nil
+	if err := fb.writeAt(decoder.Data(), sb.Offset); err != nil {
+		return false, fmt.Errorf("write block %d of TOI %d: %w", sbn, fb.TOI, err)
+	}
+	for esi := range sb.Symbols {
+		fb.setSymbol(fb.firstSymbol[sbn] + uint64(esi))
+	}
+	fb.blockSource[sbn] = sb.Symbols
+	fb.finishBlock(sbn)
+	return true, nil
+}
+
+// decoder 返回源块的解码器。首次创建时从内存预算中预留源块大小，并加入已写入的源符号
+func (fb *fileBuffer) decoder(sbn uint32) (fec.Decoder, error) {
+	if decoder, ok := fb.decoders[sbn]; ok {
+		return decoder, nil
+	}
+
+	sb := fb.blocks[sbn]
+	size := fb.decoderSize(sb)
+	if !fb.budget.reserve(size) {
+		return nil, fmt.Errorf("decoder memory budget exhausted (%d of %d bytes in use), dropping symbol for block %d of TOI %d",
+			fb.budget.used, fb.budget.limit, sbn, fb.TOI)
+	}
+	decoder, err := fb.scheme.NewDecoder(fb.oti, sb)
+	if err != nil {
+		fb.budget.release(size)
+		return nil, fmt.Errorf("create %s decoder for block %d of TOI %d: %w", fb.scheme.Name(), sbn, fb.TOI, err)
+	}
+	fb.decoders[sbn] = decoder
+
+	for esi := range sb.Symbols {
+		if !fb.hasSymbol(fb.firstSymbol[sbn] + uint64(esi)) {
+			continue
+		}
+		start, end, _ := fec.SourceSymbolRange(fb.oti, sb, esi)
+		symbol := make([]byte, fb.oti.EncodingSymbolLength)
+		if err := fb.readAt(symbol[:end-start], sb.Offset+start); err != nil {
+			fb.dropDecoder(sbn)
+			return nil, fmt.Errorf("read block %d of TOI %d: %w", sbn, fb.TOI, err)
+		}
+		if _, err := decoder.AddSymbol(esi, symbol); err != nil {
+			fb.dropDecoder(sbn)
+			return nil, fmt.Errorf("block %d of TOI %d: %w", sbn, fb.TOI, err)
+		}
+	}
+	return decoder, nil
+}
+
+func (fb *fileBuffer) decoderSize(sb oti.SourceBlock) uint64 {
+	return uint64(sb.Symbols) * uint64(fb.oti.EncodingSymbolLength)
+}
+
+// dropDecoder 释放源块的解码器及其内存预算
+func (fb *fileBuffer) dropDecoder(sbn uint32) {
+	if _, ok := fb.decoders[sbn]; !ok {
+		return
+	}
+	delete(fb.decoders, sbn)
+	fb.budget.release(fb.decoderSize(fb.blocks[sbn]))
+}
+
+func (fb *fileBuffer) finishBlock(sbn uint32) {
+	fb.blockDone[sbn] = true
+	fb.doneBlocks++
+	fb.dropDecoder(sbn)
+}
+
+func (fb *fileBuffer) hasSymbol(index uint64) bool {
+	return fb.received[index/64]&(1<<(index%64)) != 0
+}
+
+func (fb *fileBuffer) setSymbol(index uint64) {
+	if !fb.hasSymbol(index) {
+		fb.received[index/64] |= 1 << (index % 64)
+		fb.written++
+	}
+}
+
+func (fb *fileBuffer) writeAt(p []byte, off uint64) error {
+	if fb.file == nil {
+		copy(fb.data[off:], p)
+		return nil
+	}
+	_, err := fb.file.WriteAt(p, int64(off))
+	return err
+}
+
+func (fb *fileBuffer) readAt(p []byte, off uint64) error {
+	if fb.file == nil {
+		copy(p, fb.data[off:])
+		return nil
+	}
+	_, err := fb.file.ReadAt(p, int64(off))
+	return err
 }
 
 func (fb *fileBuffer) isComplete() bool {
-	if fb.TotalChunks > 0 && len(fb.Chunks) >= int(fb.TotalChunks) {
-		return true
+	return fb.TotalChunks > 0 && fb.doneBlocks >= fb.TotalChunks
+}
+
+// sourceSymbols 返回对象的源符号总数
+func (fb *fileBuffer) sourceSymbols() uint64 {
+	if len(fb.blocks) == 0 {
+		return 0
 	}
-	return fb.closeObject && len(fb.Chunks) > 0
+	last := len(fb.blocks) - 1
+	return fb.firstSymbol[last] + uint64(fb.blocks[last].Symbols)
 }
 
+// reconstruct 返回内存中的对象数据（FDT 实例）
 func (fb *fileBuffer) reconstruct() ([]byte, error) {
-	if len(fb.Chunks) == 0 {
-		return nil, fmt.Errorf("no chunks available for TOI %d", fb.TOI)
+	if fb.file != nil {
+		return nil, fmt.Errorf("TOI %d is stored in %s", fb.TOI, fb.file.Name())
 	}
+	if !fb.isComplete() {
+		return nil, fmt.Errorf("TOI %d is incomplete: %d/%d source blocks", fb.TOI, fb.doneBlocks, fb.TotalChunks)
+	}
+	return fb.data, nil
+}
 
-	keys := make([]int, 0, len(fb.Chunks))
-	for k := range fb.Chunks {
-		keys = append(keys, int(k))
+// discard 释放解码器与源块表的内存预算并删除临时文件
+func (fb *fileBuffer) discard() {
+	for sbn := range fb.decoders {
+		fb.dropDecoder(sbn)
+	}
+	fb.budget.release(fb.tables)
+	fb.tables = 0
+	if fb.file != nil {
+		fb.file.Close()
+		os.Remove(fb.file.Name())
+		fb.file = nil
 	}
-	sort.Ints(keys)
+}
 
-	totalSize := 0
-	for _, k := range keys {
-		totalSize += len(fb.Chunks[uint32(k)])
+// digest 流式计算临时文件的 MD5 与 SHA-256
+func (fb *fileBuffer) digest() ([]byte, []byte, error) {
+	md5Hash, sha256Hash := md5.New(), sha256.New()
+	r := io.NewSectionReader(fb.file, 0, int64(fb.oti.TransferLength))
+	if _, err := io.Copy(io.MultiWriter(md5Hash, sha256Hash), r); err != nil {
+		return nil, nil, fmt.Errorf("read %s: %w", fb.file.Name(), err)
 	}
+	return md5Hash.Sum(nil), sha256Hash.Sum(nil), nil
+}
 
-	reconstructed := make([]byte, 0, totalSize)
-	for _, k := range keys {
-		reconstructed = append(reconstructed, fb.Chunks[uint32(k)]...)
+// verify 按 FDT 中的长度与摘要校验重组后的对象
+func (fb *fileBuffer) verify(md5sum, sha256sum []byte) error {
+	info, err := fb.file.Stat()
+	if err != nil {
+		return err
+	}
+	if uint64(info.Size()) != fb.oti.TransferLength {
+		return fmt.Errorf("length mismatch: reconstructed %d bytes, FDT Transfer-Length is %d", info.Size(), fb.oti.TransferLength)
+	}
+	if fb.contentMD5 != "" {
+		if actual := base64.StdEncoding.EncodeToString(md5sum); actual != fb.contentMD5 {
+			return fmt.Errorf("Content-MD5 mismatch: expected %s, got %s", fb.contentMD5, actual)
+		}
+	}
+	if fb.contentSHA256 != "" {
+		if actual := base64.StdEncoding.EncodeToString(sha256sum); actual != fb.contentSHA256 {
+			return fmt.Errorf("Content-SHA256 mismatch: expected %s, got %s", fb.contentSHA256, actual)
+		}
 	}
+	return nil
+}
 
-	return reconstructed, nil
+func (fb *fileBuffer) fileName() string {
+	if fb.FileName == "" {
+		return fmt.Sprintf("toi_%d.bin", fb.TOI)
+	}
+	return fb.FileName
 }
 
-func (fb *fileBuffer) save(saveDir string) error {
-	data, err := fb.reconstruct()
+// safeFileName 校验并规范化 FDT 中的 Content-Location，另外拒绝与接收端临时文件同名的文件，
+// 以免启动时被当作残留的临时文件删除
+func safeFileName(name string) (string, error) {
+	rel, err := utils.SanitizeRelativePath(name)
+	if err != nil {
+		return "", err
+	}
+	base := path.Base(rel)
+	if strings.HasPrefix(base, tempFilePrefix) && strings.HasSuffix(base, tempFileSuffix) {
+		return "", fmt.Errorf("file name %q is reserved for temporary files", base)
+	}
+	return rel, nil
+}
+
+// moveTo 将临时文件写入磁盘后原子重命名为 path，已存在的同名文件被整体替换，
+// 不会出现写了一半的文件。跨文件系统时先复制到目标目录中的临时文件再重命名
+func (fb *fileBuffer) moveTo(path string) error {
+	src := fb.file.Name()
+	if err := fb.file.Sync(); err != nil {
+		return fmt.Errorf("sync %s: %w", src, err)
+	}
+	if err := fb.file.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", src, err)
+	}
+	fb.file = nil
+	if _, err := os.Stat(path); err == nil {
+		fmt.Printf("Replacing existing file %s\n", path)
+	}
+	if err := os.Rename(src, path); err != nil {
+		defer os.Remove(src)
+		if err := copyFileAtomic(src, path); err != nil {
+			return err
+		}
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// copyFileAtomic 将 src 复制到 dst 所在目录的临时文件，写入磁盘后重命名为 dst
+func copyFileAtomic(src, dst string) error {
+	in, err := os.Open(src)
 	if err != nil {
 		return err
 	}
+	defer in.Close()
 
-	if err := os.MkdirAll(saveDir, 0o755); err != nil {
-		return fmt.Errorf("ensure save dir: %w", err)
+	out, err := os.CreateTemp(filepath.Dir(dst), tempFilePrefix+tempFileOwner+"-copy-*"+tempFileSuffix)
+	if err != nil {
+		return err
 	}
+	tmp := out.Name()
+	if _, err = io.Copy(out, in); err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("copy %s to %s: %w", src, dst, err)
+	}
+	return nil
+}
 
-	name := fb.FileName
-	if name == "" {
-		name = fmt.Sprintf("toi_%d.bin", fb.TOI)
+// syncDir 将目录写入磁盘，使重命名在断电后仍然有效。不支持目录同步的系统上忽略错误
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && runtime.GOOS != "windows" {
+		return fmt.Errorf("sync directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+// 隔离的对象放在隔离目录的 files 子目录下，与 quarantine.log、security.log 分开
+const quarantineFilesDir = "files"
+
+// quarantineName 返回对象在隔离目录中的文件名 <TSI>-<TOI>-<文件名>，不沿用发送端给出的目录结构，
+// 超过 255 字节时截断
+func (fb *fileBuffer) quarantineName() string {
+	name := fmt.Sprintf("%d-%d-%s", fb.tsi, fb.TOI, path.Base(fb.fileName()))
+	if len(name) > 255 {
+		name = strings.ToValidUTF8(name[:255], "")
+	}
+	return name
+}
+
+// quarantine 将校验失败或不完整的对象（缺失部分为零）移入隔离目录，并在 quarantine.log 中记录原因
+func (fb *fileBuffer) quarantine(quarantineDir string, reason error) error {
+	defer fb.discard()
+	if err := os.MkdirAll(quarantineDir, 0o755); err != nil {
+		return fmt.Errorf("ensure quarantine dir: %w", err)
+	}
+
+	path, err := utils.ResolveInRoot(quarantineDir, quarantineFilesDir+"/"+fb.quarantineName())
+	if err != nil {
+		return fmt.Errorf("cannot place TOI %d under %s: %w", fb.TOI, quarantineDir, err)
 	}
-	path := filepath.Join(saveDir, name)
-	if err := os.WriteFile(path, data, 0o644); err != nil {
+	if err := fb.moveTo(path); err != nil {
 		return fmt.Errorf("write file %s: %w", path, err)
 	}
+	fb.quarantined = true
+
+	logPath := filepath.Join(quarantineDir, "quarantine.log")
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", logPath, err)
+	}
+	defer logFile.Close()
+	if _, err := fmt.Fprintf(logFile, "%s TSI=%d TOI=%d name=%q file=%s size=%d: %v\n",
+		time.Now().Format(time.RFC3339), fb.tsi, fb.TOI, fb.fileName(), filepath.Join(quarantineFilesDir, filepath.Base(path)),
+		fb.oti.TransferLength, reason); err != nil {
+		return fmt.Errorf("write %s: %w", logPath, err)
+	}
+
+	fmt.Printf("File (TOI=%d) quarantined to %s: %v\n", fb.TOI, path, reason)
+	return nil
+}
+
+// save 校验对象后将临时文件原子重命名到保存目录，校验失败时移入隔离目录。
+// verify 为 false 时跳过读取整个文件计算摘要
+func (fb *fileBuffer) save(quarantineDir string, verify bool) error {
+	defer fb.discard()
+	var md5sum []byte
+	if verify {
+		var sha256sum []byte
+		var err error
+		if md5sum, sha256sum, err = fb.digest(); err != nil {
+			return err
+		}
+		if err := fb.verify(md5sum, sha256sum); err != nil {
+			return fb.quarantine(quarantineDir, err)
+		}
+	}
 
-	md5sum := utils.CalculateMD5(data)
-	fmt.Printf("File (TOI=%d) saved to: %s\n", fb.TOI, path)
-	fmt.Printf("Reconstructed file MD5: %s\n", md5sum)
+	if err := fb.moveTo(fb.path); err != nil {
+		return fmt.Errorf("write file %s: %w", fb.path, err)
+	}
+
+	fmt.Printf("File (TOI=%d) saved to: %s\n", fb.TOI, fb.path)
+	if !verify {
+		fmt.Println("Reconstructed file not verified (skip_verify)")
+	} else if fb.contentMD5 != "" || fb.contentSHA256 != "" {
+		fmt.Printf("Reconstructed file MD5: %s (verified against FDT)\n", hex.EncodeToString(md5sum))
+	} else {
+		fmt.Printf("Reconstructed file MD5: %s (no digest in FDT, not verified)\n", hex.EncodeToString(md5sum))
+	}
 	return nil
 }
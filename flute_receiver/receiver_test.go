@@ -0,0 +1,476 @@
+package main
+
+import (
+	alc "FluteTest/pkg/alc"
+	fdt "FluteTest/pkg/fdt"
+	fd "FluteTest/pkg/filedesc"
+	oti "FluteTest/pkg/oti"
+	sender "FluteTest/pkg/sender"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loopback 通过本机 UDP 把发送端的数据包交给接收端的会话
+type loopback struct {
+	t      *testing.T
+	listen *net.UDPConn
+	conn   *net.UDPConn
+	queue  *receiveQueue
+	closed map[uint64]int // 各 TOI 设置了 Close Object 标志的数据包数
+}
+
+func newLoopback(t *testing.T, saveDir, quarantineDir string) *loopback {
+	t.Helper()
+	listen, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen.SetReadBuffer(8 << 20)
+	conn, err := net.DialUDP("udp", nil, listen.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		listen.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		listen.Close()
+	})
+	queue := newReceiveQueue(saveDir, quarantineDir, defaultMemoryBudgetMB<<20)
+	queue.verify = true
+	return &loopback{t: t, listen: listen, conn: conn, queue: queue, closed: make(map[uint64]int)}
+}
+
+// drain 处理已到达的全部数据包，直到一段时间内没有新的数据包
+func (l *loopback) drain() {
+	l.t.Helper()
+	buf := make([]byte, 65507)
+	for {
+		l.listen.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, addr, err := l.listen.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		pkt, err := alc.ParseAlcPkt(buf[:n])
+		if err != nil {
+			l.t.Fatalf("parse packet: %v", err)
+		}
+		if pkt.LCTHeader.CloseObject {
+			l.closed[pkt.LCTHeader.TOI]++
+		}
+		if len(pkt.EncodingSymbols) == 0 {
+			continue
+		}
+		s := l.queue.session(sessionKey{source: addr.IP.String(), tsi: pkt.LCTHeader.TSI}, time.Now())
+		if pkt.LCTHeader.TOI == 0 {
+			s.handleFDT(pkt, addr)
+		} else {
+			s.handleDataPkt(pkt, addr)
+		}
+	}
+}
+
+func (l *loopback) session() *session {
+	l.t.Helper()
+	if len(l.queue.sessions) != 1 {
+		l.t.Fatalf("%d sessions, want 1", len(l.queue.sessions))
+	}
+	for _, s := range l.queue.sessions {
+		return s
+	}
+	return nil
+}
+
+// TestCarouselSavesEachFileOnce 轮播多轮时每个文件只保存一次
+func TestCarouselSavesEachFileOnce(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		oti  oti.Oti
+	}{
+		{"no-code", oti.NewNoCode(1024, 64)},
+		{"ReedSolomon", oti.NewReedSolomon(1024, 64, 0)},
+		{"RaptorQ", oti.NewRaptorQ(1024, 64, 1024)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srcDir, saveDir := t.TempDir(), t.TempDir()
+			l := newLoopback(t, saveDir, filepath.Join(t.TempDir(), "quarantine"))
+
+			s := sender.NewSender(l.conn, 1, tc.oti, nil, sender.SenderConfig{FdtExpires: time.Minute})
+			var files []*fd.FileDesc
+			for i, size := range []int{3000, 70 * 1024, 1} {
+				data := bytes.Repeat([]byte{byte('a' + i)}, size)
+				path := filepath.Join(srcDir, fmt.Sprintf("file%d.bin", i))
+				if err := os.WriteFile(path, data, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				sum := md5.Sum(data)
+				desc := &fd.FileDesc{Path: path, Name: filepath.Base(path), Size: int64(size),
+					ContentType: "application/octet-stream", Md5: hex.EncodeToString(sum[:])}
+				if err := sender.AddFile(s, desc); err != nil {
+					t.Fatal(err)
+				}
+				files = append(files, desc)
+			}
+			s.CompleteFDT()
+
+			const rounds = 3
+			for round := uint32(0); round < rounds; round++ {
+				if err := s.SendFDT(); err != nil {
+					t.Fatal(err)
+				}
+				for _, desc := range files {
+					f, err := os.Open(desc.Path)
+					if err != nil {
+						t.Fatal(err)
+					}
+					err = s.SendRound(desc, f, round, round == rounds-1)
+					f.Close()
+					if err != nil {
+						t.Fatal(err)
+					}
+					l.drain()
+				}
+			}
+
+			sess := l.session()
+			if sess.saved != len(files) || sess.quarantined != 0 {
+				t.Fatalf("after %d rounds: %d saved, %d quarantined, want %d saved", rounds, sess.saved, sess.quarantined, len(files))
+			}
+			// 只有最后一轮关闭对象，FDT 不关闭
+			for _, desc := range files {
+				if l.closed[desc.TOI] != 1 {
+					t.Fatalf("TOI %d closed in %d packets over %d rounds, want 1", desc.TOI, l.closed[desc.TOI], rounds)
+				}
+			}
+			if l.closed[0] != 0 {
+				t.Fatalf("FDT closed in %d packets", l.closed[0])
+			}
+			for _, desc := range files {
+				want, _ := os.ReadFile(desc.Path)
+				got, err := os.ReadFile(filepath.Join(saveDir, desc.Name))
+				if err != nil || !bytes.Equal(got, want) {
+					t.Fatalf("%s not received intact: %v", desc.Name, err)
+				}
+			}
+		})
+	}
+}
+
+// TestFDTUpdateKeepsEmptyObjects FDT 实例更新时，描述不变的零长度文件与目录不会重新创建
+func TestFDTUpdateKeepsEmptyObjects(t *testing.T) {
+	srcDir, saveDir := t.TempDir(), t.TempDir()
+	l := newLoopback(t, saveDir, filepath.Join(t.TempDir(), "quarantine"))
+	s := sender.NewSender(l.conn, 1, oti.NewNoCode(1024, 64), nil, sender.SenderConfig{FdtExpires: time.Minute})
+
+	addEmpty := func(name string, dir bool) {
+		t.Helper()
+		desc := &fd.FileDesc{Path: filepath.Join(srcDir, name), Name: name, Directory: dir,
+			ContentType: "application/octet-stream"}
+		if err := sender.AddFile(s, desc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addEmpty("empty0", false)
+	addEmpty("dir", true)
+	if err := s.SendFDT(); err != nil {
+		t.Fatal(err)
+	}
+	l.drain()
+
+	sess := l.session()
+	if sess.saved != 1 {
+		t.Fatalf("%d files saved after the first FDT instance, want 1", sess.saved)
+	}
+	stamp := time.Now().Add(-time.Hour)
+	for _, name := range []string{"empty0", "dir"} {
+		if err := os.Chtimes(filepath.Join(saveDir, name), stamp, stamp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 登记新文件使发送端生成新的 FDT 实例，其中仍包含前两个条目
+	addEmpty("empty1", false)
+	if err := s.SendFDT(); err != nil {
+		t.Fatal(err)
+	}
+	l.drain()
+
+	if sess.saved != 2 {
+		t.Fatalf("%d files saved after the second FDT instance, want 2", sess.saved)
+	}
+	for _, name := range []string{"empty0", "dir"} {
+		info, err := os.Stat(filepath.Join(saveDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(stamp) {
+			t.Fatalf("%s re-created by the second FDT instance", name)
+		}
+	}
+}
+
+// TestFlushSavesOutOfOrder 排在前面的对象未完成时，后面已完整的对象也立即保存
+func TestFlushSavesOutOfOrder(t *testing.T) {
+	saveDir := t.TempDir()
+	q := newReceiveQueue(saveDir, t.TempDir(), defaultMemoryBudgetMB<<20)
+	s := q.session(sessionKey{source: "10.0.0.1", tsi: 1}, time.Now())
+	info, err := oti.NewNoCode(16, 64).WithTransferLength(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for toi := uint64(1); toi <= 2; toi++ {
+		file := fdt.File{ContentLocation: fmt.Sprintf("file%d.bin", toi)}
+		file.SetOTI(info)
+		if _, err := s.create(toi, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for esi := uint32(0); esi < 2; esi++ {
+		pkt := &alc.AlcPkt{OTI: info, EncodingSymbol: esi, EncodingSymbols: bytes.Repeat([]byte{'b'}, 16)}
+		if _, err := s.files[2].storeSymbol(pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.flushReady()
+
+	if s.saved != 1 || len(s.order) != 1 || s.order[0] != 1 {
+		t.Fatalf("%d saved, order %v after TOI 2 completed, want 1 saved and TOI 1 pending", s.saved, s.order)
+	}
+	if _, err := os.Stat(filepath.Join(saveDir, "file2.bin")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestFailedSaveNotCompleted 保存失败的对象计为失败，不记为已完成
+func TestFailedSaveNotCompleted(t *testing.T) {
+	saveDir := t.TempDir()
+	q := newReceiveQueue(saveDir, t.TempDir(), defaultMemoryBudgetMB<<20)
+	s := q.session(sessionKey{source: "10.0.0.1", tsi: 1}, time.Now())
+	info, err := oti.NewNoCode(16, 64).WithTransferLength(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := fdt.File{ContentLocation: "file.bin"}
+	file.SetOTI(info)
+	fb, err := s.create(1, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 接收期间最终路径被非空目录占用，重命名失败
+	if err := os.MkdirAll(filepath.Join(saveDir, "file.bin", "x"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fb.storeSymbol(&alc.AlcPkt{OTI: info, EncodingSymbols: make([]byte, 16)}); err != nil {
+		t.Fatal(err)
+	}
+	s.flushReady()
+
+	if _, ok := s.completed[1]; ok || s.saved != 0 || s.failed != 1 || len(s.files) != 0 {
+		t.Fatalf("completed=%v saved=%d failed=%d in progress=%d after failed save, want only 1 failed",
+			ok, s.saved, s.failed, len(s.files))
+	}
+}
+
+// TestDuplicateSourceSymbolsCountedOnce 重复收到的源符号不计入已接收的符号数
+func TestDuplicateSourceSymbolsCountedOnce(t *testing.T) {
+	info, err := oti.NewNoCode(16, 64).WithTransferLength(64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb, err := newFileBuffer(1, info, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, esi := range []uint32{0, 1, 1, 0, 2} {
+		pkt := &alc.AlcPkt{OTI: info, EncodingSymbol: esi, EncodingSymbols: make([]byte, 16)}
+		if _, err := fb.storeSymbol(pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fb.symbols != 3 || fb.written != 3 {
+		t.Fatalf("symbols=%d written=%d after 3 distinct symbols, want 3", fb.symbols, fb.written)
+	}
+}
+
+// TestOversizedOTIRejected 超出方案上限的 OTI 与源块表超出内存预算的对象在分配之前被拒绝，
+// 接收完成后源块表占用的预算被释放
+func TestOversizedOTIRejected(t *testing.T) {
+	budget := &memoryBudget{limit: 1 << 20}
+	for name, info := range map[string]oti.Oti{
+		"SBN beyond 16 bits": {FECEncodingID: oti.FECEncodingNoCode, EncodingSymbolLength: 1, MaximumSourceBlockLength: 1, TransferLength: 1 << 44},
+		"ESI beyond 8 bits":  {FECEncodingID: oti.FECEncodingReedSolomon, EncodingSymbolLength: 1, MaximumSourceBlockLength: 300, MaxEncodingSymbols: 300, TransferLength: 1 << 20},
+		"over budget":        {FECEncodingID: oti.FECEncodingNoCode, EncodingSymbolLength: 1, MaximumSourceBlockLength: 1 << 16, TransferLength: 1 << 32},
+	} {
+		if _, err := newFileBuffer(1, info, t.TempDir(), budget); err == nil {
+			t.Errorf("%s: object accepted", name)
+		}
+		if budget.used != 0 {
+			t.Fatalf("%s: %d bytes of budget still in use", name, budget.used)
+		}
+	}
+
+	info, err := oti.NewNoCode(16, 64).WithTransferLength(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb, err := newFileBuffer(1, info, t.TempDir(), budget)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if budget.used == 0 {
+		t.Fatal("block tables not counted in budget")
+	}
+	fb.discard()
+	if budget.used != 0 {
+		t.Fatalf("%d bytes of budget still in use after discard", budget.used)
+	}
+}
+
+// TestRejectedRepairSymbolNotCounted 内存预算用尽、无法创建解码器时丢弃的修复符号不计入已接收的符号数
+func TestRejectedRepairSymbolNotCounted(t *testing.T) {
+	info, err := oti.NewReedSolomon(16, 8, 4).WithTransferLength(8 * 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	budget := &memoryBudget{limit: 1 << 20}
+	fb, err := newFileBuffer(1, info, "", budget)
+	if err != nil {
+		t.Fatal(err)
+	}
+	budget.limit = budget.used
+	pkt := &alc.AlcPkt{OTI: info, EncodingSymbol: 8, EncodingSymbols: make([]byte, 16)}
+	if _, err := fb.storeSymbol(pkt); err == nil {
+		t.Fatal("repair symbol stored beyond the memory budget")
+	}
+	if fb.symbols != 0 {
+		t.Fatalf("symbols=%d after the only repair symbol was dropped, want 0", fb.symbols)
+	}
+
+	budget.limit = 1 << 20
+	if _, err := fb.storeSymbol(pkt); err != nil {
+		t.Fatal(err)
+	}
+	if fb.symbols != 1 {
+		t.Fatalf("symbols=%d after a repair symbol was accepted, want 1", fb.symbols)
+	}
+}
+
+// TestPendingPacketsCountedInBudget 等待 FDT 的数据包计入内存预算，预算用尽时先丢弃暂存数据最多的会话
+func TestPendingPacketsCountedInBudget(t *testing.T) {
+	q := newReceiveQueue(t.TempDir(), t.TempDir(), 4096)
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	send := func(s *session, toi uint64) {
+		pkt := &alc.AlcPkt{EncodingSymbols: make([]byte, 1024)}
+		pkt.LCTHeader.TSI, pkt.LCTHeader.TOI = s.key.tsi, toi
+		s.handleDataPkt(pkt, addr)
+	}
+
+	now := time.Now()
+	flood := q.session(sessionKey{source: "10.0.0.1", tsi: 1}, now)
+	for toi := uint64(1); toi <= 8; toi++ {
+		send(flood, toi)
+	}
+	if q.pendingPkts != 4 || q.budget.used != 4096 {
+		t.Fatalf("%d packets, %d bytes pending, want 4 packets within the 4096-byte budget", q.pendingPkts, q.budget.used)
+	}
+
+	other := q.session(sessionKey{source: "10.0.0.2", tsi: 1}, now)
+	send(other, 1)
+	if flood.pendingLen != 0 || other.pendingLen != 1024 || q.budget.used != 1024 {
+		t.Fatalf("pending bytes: flooding session %d, other session %d, budget %d; want 0, 1024, 1024",
+			flood.pendingLen, other.pendingLen, q.budget.used)
+	}
+
+	q.closeAll()
+	if q.pendingPkts != 0 || q.budget.used != 0 {
+		t.Fatalf("%d packets, %d bytes still pending after closing all sessions", q.pendingPkts, q.budget.used)
+	}
+}
+
+// TestCleanTempFilesKeepsOtherReceivers 启动时只删除本机已退出进程遗留的临时文件
+func TestCleanTempFilesKeepsOtherReceivers(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a Unix command to obtain the PID of an exited process")
+	}
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Skip(err)
+	}
+	host, _, _ := strings.Cut(tempFileOwner, "@")
+
+	dir := t.TempDir()
+	files := map[string]bool{ // 文件名 -> 是否应被删除
+		fmt.Sprintf(".flute-%s@%d-toi1-1.tmp", host, exited.Process.Pid):       true,
+		fmt.Sprintf(".flute-%s@%d-copy-2.tmp", host, exited.Process.Pid):       true,
+		fmt.Sprintf(".flute-%s@%d-toi1-3.tmp", host, os.Getppid()):             false,
+		fmt.Sprintf(".flute-other-%s@%d-toi1-4.tmp", host, exited.Process.Pid): false,
+		".flute-toi1-5.tmp": false,
+		"data.bin":          false,
+	}
+	for name := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cleanTempFiles(dir)
+	for name, removed := range files {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists == removed {
+			t.Errorf("%s: exists=%v after cleanup, want removed=%v", name, exists, removed)
+		}
+	}
+}
+
+// TestQuarantineKeepsLogFiles 隔离的文件使用生成的文件名，不会覆盖隔离目录中的日志
+func TestQuarantineKeepsLogFiles(t *testing.T) {
+	srcDir, saveDir, quarantineDir := t.TempDir(), t.TempDir(), t.TempDir()
+	audit := []byte("audit trail\n")
+	if err := os.WriteFile(filepath.Join(quarantineDir, "security.log"), audit, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l := newLoopback(t, saveDir, quarantineDir)
+	s := sender.NewSender(l.conn, 7, oti.NewNoCode(1024, 64), nil, sender.SenderConfig{FdtExpires: time.Minute})
+
+	data := []byte("not what the FDT describes")
+	path := filepath.Join(srcDir, "security.log")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	desc := &fd.FileDesc{Path: path, Name: "logs/security.log", Size: int64(len(data)),
+		ContentType: "text/plain", Md5: hex.EncodeToString(make([]byte, md5.Size))}
+	if err := sender.AddFile(s, desc); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SendFDT(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s.Send(desc, f) // 摘要与文件内容不符，发送端在发送完成后报错
+	l.drain()
+
+	if sess := l.session(); sess.quarantined != 1 {
+		t.Fatalf("%d files quarantined, want 1", sess.quarantined)
+	}
+	if got, _ := os.ReadFile(filepath.Join(quarantineDir, "security.log")); !bytes.Equal(got, audit) {
+		t.Fatalf("security.log overwritten: %q", got)
+	}
+	got, err := os.ReadFile(filepath.Join(quarantineDir, quarantineFilesDir, fmt.Sprintf("7-%d-security.log", desc.TOI)))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("quarantined file not found under its generated name: %v", err)
+	}
+}
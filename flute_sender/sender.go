@@ -1,20 +1,24 @@
 package main
 
 import (
-	"FluteTest/pkg/fdt"
+	fec "FluteTest/pkg/fec"
 	fd "FluteTest/pkg/filedesc"
 	o "FluteTest/pkg/oti"
 	sender "FluteTest/pkg/sender"
 	ep "FluteTest/pkg/udpendpoint"
 	utils "FluteTest/pkg/utils"
+	"compress/gzip"
+	"compress/zlib"
 	"fmt"
-	"net"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"time"
 
-	raptorq "github.com/xssnick/raptorq"
 	"gopkg.in/yaml.v3"
 )
 
@@ -27,24 +31,51 @@ type senderStaticARP struct {
 
 type senderNetwork struct {
 	SourceIP string `yaml:"source_ip"`
-	DestIP   string `yaml:"dest_ip"`
+	DestIP   string `yaml:"dest_ip"` // 单播地址或组播组地址
 	Port     int    `yaml:"port"`
+
+	// 仅组播
+	Interface         string `yaml:"interface"`          // 出接口，空表示由系统选择
+	MulticastTTL      int    `yaml:"multicast_ttl"`      // 0 表示默认值 1
+	MulticastLoopback bool   `yaml:"multicast_loopback"` // 是否将组播包回送到本机
+}
+
+type senderCarousel struct {
+	Enable    bool   `yaml:"enable"`
+	Rounds    uint32 `yaml:"rounds"`     // 轮数，0 表示不限
+	DurationS int    `yaml:"duration_s"` // 总时长（秒），0 表示不限；两者都为 0 时一直轮播
 }
 
 type senderTransmission struct {
-	FdtDurationMs        int    `yaml:"fdt_duration_ms"`
-	FdtStartID           uint32 `yaml:"fdt_start_id"`
+	FdtDurationMs int            `yaml:"fdt_duration_ms"`
+	FdtStartID    uint32         `yaml:"fdt_start_id"`
+	FdtExpiresS   int            `yaml:"fdt_expires_s"`
+	ContentSHA256 bool           `yaml:"content_sha256"` // 是否在 FDT 中额外携带 SHA-256 摘要
+	RateBps       uint64         `yaml:"rate_bps"`       // 发送速率（bit/s），0 表示不限速
+	BurstBytes    uint64         `yaml:"burst_bytes"`    // 令牌桶容量（字节），0 表示按 10ms 的发送量
+	CloseSession  bool           `yaml:"close_session"`  // 发送结束后是否发送关闭会话包
+	Carousel      senderCarousel `yaml:"carousel"`
 }
 
 type senderFile struct {
-	Path        string `yaml:"path"`
-	Name        string `yaml:"name"`
-	ContentType string `yaml:"content_type"`
+	Path            string `yaml:"path"`
+	Name            string `yaml:"name"`
+	ContentType     string `yaml:"content_type"`
+	ContentEncoding string `yaml:"content_encoding"`
+	RepairOverhead  string `yaml:"repair_overhead"` // 可选，覆盖 fec.repair_overhead
 }
 
-type fec struct {
-	Type                  string `yaml:"type"`
-	EncodingSymbolLength  uint16 `yaml:"encoding_symbol_length"`
+type senderFEC struct {
+	Type                 string  `yaml:"type"`
+	EncodingSymbolLength uint16  `yaml:"encoding_symbol_length"`
+	RepairOverhead       string  `yaml:"repair_overhead"` // "20%"（K 的百分比）、"32"（修复符号数）或 "auto"
+	RepairSymbols        uint32  `yaml:"repair_symbols"`  // 已由 repair_overhead 取代，仅在其未设置时生效
+	ExpectedLoss         float64 `yaml:"expected_loss"`   // auto 使用的预计丢包率
+	TargetSuccess        float64 `yaml:"target_success"`  // auto 使用的目标恢复概率（每个源块）
+	MaxSourceBlockLength uint32  `yaml:"max_source_block_length"`
+	MaxSubBlockSize      uint32  `yaml:"max_sub_block_size"`
+	LDPCN1               uint8   `yaml:"ldpc_n1"`
+	LDPCSeed             uint32  `yaml:"ldpc_seed"`
 }
 
 type senderAppConfig struct {
@@ -52,7 +83,7 @@ type senderAppConfig struct {
 	Network      senderNetwork      `yaml:"network"`
 	Transmission senderTransmission `yaml:"transmission"`
 	Files        []senderFile       `yaml:"files"`
-	FEC          fec                 `yaml:"fec"`
+	FEC          senderFEC          `yaml:"fec"`
 }
 
 func main() {
@@ -67,24 +98,35 @@ func main() {
 	}
 
 	queue := make([]*fd.FileDesc, 0, len(cfg.Files))
+	seen := make(map[string]string) // Content-Location -> 本地路径，用于发现重名
 	for _, entry := range cfg.Files {
 		if entry.Path == "" {
 			fmt.Println("skip file entry with empty path in config")
 			continue
 		}
-		name := entry.Name
-		if name == "" {
-			name = filepath.Base(entry.Path)
+		var overhead *fec.RepairOverhead
+		if entry.RepairOverhead != "" {
+			value, err := parseRepairOverhead(entry.RepairOverhead, cfg.FEC)
+			if err != nil {
+				fmt.Printf("skip file %s: %v\n", entry.Path, err)
+				continue
+			}
+			overhead = &value
 		}
-		contentType := entry.ContentType
-		if contentType == "" {
-			contentType = "application/octet-stream"
+		descs, err := expandFileEntry(entry)
+		if err != nil {
+			fmt.Printf("skip file %s: %v\n", entry.Path, err)
+			continue
+		}
+		for _, filedesc := range descs {
+			if prev, ok := seen[filedesc.Name]; ok {
+				fmt.Printf("skip %s: name %q already used by %s\n", filedesc.Path, filedesc.Name, prev)
+				continue
+			}
+			seen[filedesc.Name] = filedesc.Path
+			filedesc.RepairOverhead = overhead
+			queue = append(queue, filedesc)
 		}
-		queue = append(queue, &fd.FileDesc{
-			Path:        entry.Path,
-			Name:        name,
-			ContentType: contentType,
-		})
 	}
 	if len(queue) == 0 {
 		fmt.Println("no valid files configured, nothing to send")
@@ -95,69 +137,300 @@ func main() {
 		SourceAddr: cfg.Network.SourceIP,
 		DestAddr:   cfg.Network.DestIP,
 		Port:       cfg.Network.Port,
+		Interface:  cfg.Network.Interface,
+		TTL:        cfg.Network.MulticastTTL,
+		Loopback:   cfg.Network.MulticastLoopback,
+	}
+	if endpointCfg.IsMulticast() {
+		fmt.Printf("Multicast session: group %s:%d, interface %q, TTL %d, loopback %v\n", endpointCfg.DestAddr,
+			endpointCfg.Port, endpointCfg.Interface, endpointCfg.TTL, endpointCfg.Loopback)
 	}
 
 	sendCfg := sender.SenderConfig{
 		FdtDuration: time.Duration(cfg.Transmission.FdtDurationMs) * time.Millisecond,
 		FdtStartID:  cfg.Transmission.FdtStartID,
+		FdtExpires:  time.Duration(cfg.Transmission.FdtExpiresS) * time.Second,
+		Rate:        cfg.Transmission.RateBps,
+		Burst:       cfg.Transmission.BurstBytes,
 	}
-
-	oti := o.NewNoCode(cfg.FEC.EncodingSymbolLength)
-	if cfg.FEC.Type == "RaptorQ" {
-		oti = o.NewRaptorQ(cfg.FEC.EncodingSymbolLength)
+	sendCfg.RepairOverhead, err = parseRepairOverhead(cfg.FEC.RepairOverhead, cfg.FEC)
+	if err != nil {
+		fmt.Printf("invalid fec config: %v\n", err)
+		return
 	}
-	
-
-	rq := raptorq.NewRaptorQ(uint32(cfg.FEC.EncodingSymbolLength))
 
-	// 创建 UDP 连接
-	destIP := net.ParseIP(endpointCfg.DestAddr)
-	if destIP == nil {
-		fmt.Printf("invalid destination IP: %s\n", endpointCfg.DestAddr)
+	scheme, ok := fec.LookupName(cfg.FEC.Type)
+	if !ok {
+		fmt.Printf("unsupported FEC type: %s\n", cfg.FEC.Type)
 		return
 	}
-	remoteAddr := &net.UDPAddr{IP: destIP, Port: endpointCfg.Port}
-
-	var localAddr *net.UDPAddr
-	if endpointCfg.SourceAddr != "" {
-		ip := net.ParseIP(endpointCfg.SourceAddr)
-		if ip == nil {
-			fmt.Printf("invalid source IP: %s", endpointCfg.SourceAddr)
-		}
-		localAddr = &net.UDPAddr{IP: ip}
+	var oti o.Oti
+	switch scheme.EncodingID() {
+	case o.FECEncodingRaptorQ:
+		oti = o.NewRaptorQ(cfg.FEC.EncodingSymbolLength, cfg.FEC.MaxSourceBlockLength, cfg.FEC.MaxSubBlockSize)
+	// Reed-Solomon 与 LDPC-Staircase 的 max_n 由发送端按每个文件的修复冗余设置
+	case o.FECEncodingReedSolomon:
+		oti = o.NewReedSolomon(cfg.FEC.EncodingSymbolLength, cfg.FEC.MaxSourceBlockLength, 0)
+	case o.FECEncodingLDPCStaircase:
+		oti = o.NewLDPCStaircase(cfg.FEC.EncodingSymbolLength, cfg.FEC.MaxSourceBlockLength, 0, cfg.FEC.LDPCN1, cfg.FEC.LDPCSeed)
+	default:
+		oti = o.NewNoCode(cfg.FEC.EncodingSymbolLength, cfg.FEC.MaxSourceBlockLength)
+		oti.FECEncodingID = scheme.EncodingID()
 	}
 
-	conn, err := net.DialUDP("udp", localAddr, remoteAddr)
+	// 创建 UDP 连接，目的地址为组播组时同时设置组播参数
+	conn, err := endpointCfg.Dial()
 	if err != nil {
-		fmt.Printf("dial UDP failed: %v", err)
+		fmt.Printf("dial UDP failed: %v\n", err)
+		return
 	}
 	defer conn.Close()
 
 	senderFileCfg := &sender.FileConfig{}
-	senderFdt := &fdt.ExtFDT{}
-	s := sender.NewSender(conn, senderFdt, 1, oti, senderFileCfg, sendCfg, rq)
+	s := sender.NewSender(conn, 1, oti, senderFileCfg, sendCfg)
 
-	// 处理文件队列
+	// 先将所有文件登记到 FDT 实例中，使 FDT 描述整个会话
+	sendQueue := make([]*fd.FileDesc, 0, len(queue))
+	registered := 0
 	for _, filedesc := range queue {
-		// 读取文件
-		fileData, err := os.ReadFile(filedesc.Path)
+		if filedesc.Directory {
+			// 目录只登记到 FDT 中，不发送数据
+			if err := sender.AddFile(s, filedesc); err != nil {
+				fmt.Println("Add directory failed:", err)
+				continue
+			}
+			registered++
+			continue
+		}
+		info, err := os.Stat(filedesc.Path)
 		if err != nil {
-			fmt.Println("Read file failed:", err)
+			fmt.Println("Stat file failed:", err)
 			continue // 继续处理下一个文件
 		}
-		filedesc.Size = int64(len(fileData))
-		filedesc.Md5 = utils.CalculateMD5(fileData)
+		md5sum, sha256sum, err := utils.CalculateFileDigests(filedesc.Path, cfg.Transmission.ContentSHA256)
+		if err != nil {
+			fmt.Println("Read file failed:", err)
+			continue
+		}
+		filedesc.Size = info.Size()
+		filedesc.Md5 = md5sum
+		filedesc.Sha256 = sha256sum
+		if filedesc.ContentEncoding != "" {
+			if filedesc.ContentLength, err = decodedLength(filedesc.Path, filedesc.ContentEncoding); err != nil {
+				fmt.Printf("Content-Length of %s unknown, omitted from FDT: %v\n", filedesc.Path, err)
+			}
+		}
 
-		sender.AddFile(s, filedesc)
-		fmt.Printf("Sending file %s with FDT ID %d\n", filedesc.Name, filedesc.FdtID)
-		serr := s.Send(&fileData)
-		if serr != nil {
-			fmt.Println("Send file failed:", serr)
-			continue // 继续处理下一个文件
+		if err := sender.AddFile(s, filedesc); err != nil {
+			fmt.Println("Add file failed:", err)
+			continue
+		}
+		sendQueue = append(sendQueue, filedesc)
+		registered++
+	}
+	if registered == 0 {
+		fmt.Println("no readable files, nothing to send")
+		return
+	}
+	s.CompleteFDT()
+
+	if err := s.SendFDT(); err != nil {
+		fmt.Println("Send FDT failed:", err)
+	}
+
+	// 处理文件队列。轮播模式下按轮重复发送整个队列，直到达到轮数或时长
+	carousel := cfg.Transmission.Carousel
+	rounds := uint32(1)
+	var deadline time.Time
+	if carousel.Enable {
+		rounds = carousel.Rounds
+		if carousel.DurationS > 0 {
+			deadline = time.Now().Add(time.Duration(carousel.DurationS) * time.Second)
+		}
+		fmt.Printf("Carousel mode: rounds=%d duration=%ds (0 = unlimited)\n", carousel.Rounds, carousel.DurationS)
+	}
+	expired := func() bool { return !deadline.IsZero() && time.Now().After(deadline) }
+
+	for round := uint32(0); (rounds == 0 || round < rounds) && !expired(); round++ {
+		if carousel.Enable {
+			fmt.Printf("Carousel round %d\n", round)
+		}
+		for _, filedesc := range sendQueue {
+			if expired() {
+				break
+			}
+			// 打开文件，发送时按源块逐块读取
+			file, err := os.Open(filedesc.Path)
+			if err != nil {
+				fmt.Println("Read file failed:", err)
+				continue // 继续处理下一个文件
+			}
+
+			fmt.Printf("Sending file %s with TOI %d (FDT instance %d)\n", filedesc.Name, filedesc.TOI, filedesc.FdtID)
+			// 只有确定不再有下一轮时才关闭对象；按时长轮播时无法预知最后一轮，不设置 B 位
+			lastRound := rounds != 0 && round == rounds-1
+			serr := s.SendRound(filedesc, file, round, lastRound)
+			file.Close()
+			if serr != nil {
+				fmt.Println("Send file failed:", serr)
+				continue // 继续处理下一个文件
+			}
+		}
+
+		// 每轮结束再发送一次 FDT，便于迟到的接收端获取文件描述
+		if err := s.SendFDT(); err != nil {
+			fmt.Println("Send FDT failed:", err)
 		}
 	}
 
 	fmt.Println("All files sent.")
+
+	if cfg.Transmission.CloseSession {
+		if err := s.CloseSession(); err != nil {
+			fmt.Println("Close session failed:", err)
+		}
+	}
+}
+
+// expandFileEntry 展开 files 中的一项。path 可以是文件、目录或 glob 模式（如 ./logs/*.log）：
+// 文件的 Content-Location 为 name（默认为文件名）；目录递归展开，其中的文件以 name（默认为目录名）
+// 加上在目录中的相对路径命名，不含文件的目录单独登记以便接收端重建空目录；
+// glob 匹配到的每个文件或目录按上述规则展开，name 作为它们的上级目录（默认放在根目录下）
+func expandFileEntry(entry senderFile) ([]*fd.FileDesc, error) {
+	contentType := entry.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	newDesc := func(localPath, name string) *fd.FileDesc {
+		return &fd.FileDesc{
+			Path:            localPath,
+			Name:            name,
+			ContentType:     contentType,
+			ContentEncoding: entry.ContentEncoding,
+		}
+	}
+
+	isGlob := strings.ContainsAny(entry.Path, "*?[")
+	matches := []string{entry.Path}
+	if isGlob {
+		var err error
+		if matches, err = filepath.Glob(entry.Path); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern: %w", err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("glob pattern matches no files")
+		}
+	}
+
+	var descs []*fd.FileDesc
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			return nil, err
+		}
+		var base string
+		switch {
+		case isGlob:
+			base = path.Join(filepath.ToSlash(entry.Name), filepath.Base(match))
+		case entry.Name != "":
+			base = path.Clean(filepath.ToSlash(entry.Name))
+		default:
+			base = filepath.Base(match)
+		}
+		if !info.IsDir() {
+			descs = append(descs, newDesc(match, base))
+			continue
+		}
+
+		var dirs []*fd.FileDesc
+		nonEmpty := make(map[string]bool) // 含有文件或已登记子目录的目录（包括间接含有）
+		err = filepath.WalkDir(match, func(p string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(match, p)
+			if err != nil {
+				return err
+			}
+			name := path.Join(base, filepath.ToSlash(rel))
+			if d.IsDir() {
+				dirs = append(dirs, &fd.FileDesc{Path: p, Name: name, Directory: true})
+				return nil
+			}
+			// 符号链接按其目标处理，目录链接不展开，以免循环
+			if info, err := os.Stat(p); err != nil || !info.Mode().IsRegular() {
+				fmt.Printf("skip %s: not a regular file\n", p)
+				return nil
+			}
+			descs = append(descs, newDesc(p, name))
+			for dir := path.Dir(name); dir != "." && dir != "/" && !nonEmpty[dir]; dir = path.Dir(dir) {
+				nonEmpty[dir] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("walk %s: %w", match, err)
+		}
+		// 子目录在父目录之后遍历，逆序处理使得只登记最深一层的空目录，其上级目录由接收端随之创建
+		for i := len(dirs) - 1; i >= 0; i-- {
+			dir := dirs[i]
+			if dir.Name == "." || nonEmpty[dir.Name] {
+				continue
+			}
+			descs = append(descs, dir)
+			for parent := path.Dir(dir.Name); parent != "." && parent != "/" && !nonEmpty[parent]; parent = path.Dir(parent) {
+				nonEmpty[parent] = true
+			}
+		}
+	}
+	return descs, nil
+}
+
+// decodedLength 按 Content-Encoding 解码文件并返回解码后的长度，支持 gzip 与 deflate（zlib 格式）
+func decodedLength(localPath, encoding string) (int64, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var r io.ReadCloser
+	switch strings.ToLower(encoding) {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(f)
+	case "deflate":
+		r, err = zlib.NewReader(f)
+	default:
+		return 0, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("decode %s: %w", encoding, err)
+	}
+	defer r.Close()
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return 0, fmt.Errorf("decode %s: %w", encoding, err)
+	}
+	return n, nil
+}
+
+// parseRepairOverhead 解析 repair_overhead，auto 时使用 fec 段中的预计丢包率与目标恢复概率
+func parseRepairOverhead(value string, cfg senderFEC) (fec.RepairOverhead, error) {
+	overhead, err := fec.ParseRepairOverhead(value)
+	if err != nil {
+		return overhead, err
+	}
+	if overhead.Auto {
+		if cfg.ExpectedLoss < 0 || cfg.ExpectedLoss >= 1 {
+			return overhead, fmt.Errorf("expected_loss %g out of range [0, 1)", cfg.ExpectedLoss)
+		}
+		if cfg.TargetSuccess <= 0 || cfg.TargetSuccess >= 1 {
+			return overhead, fmt.Errorf("target_success %g out of range (0, 1)", cfg.TargetSuccess)
+		}
+		overhead.LossRate = cfg.ExpectedLoss
+		overhead.Success = cfg.TargetSuccess
+	}
+	return overhead, nil
 }
 
 func loadSenderConfig(path string) (*senderAppConfig, error) {
@@ -170,13 +443,28 @@ func loadSenderConfig(path string) (*senderAppConfig, error) {
 		return nil, fmt.Errorf("parse config: %w", err)
 	}
 
+	if cfg.FEC.Type == "" {
+		cfg.FEC.Type = "no-code"
+	}
 	if cfg.FEC.EncodingSymbolLength == 0 {
 		cfg.FEC.EncodingSymbolLength = 10240
 		fmt.Printf("FEC EncodingSymbolLength not set, using default %d\n", cfg.FEC.EncodingSymbolLength)
 	}
+	if cfg.FEC.MaxSourceBlockLength == 0 {
+		cfg.FEC.MaxSourceBlockLength = 1024
+	}
+	if cfg.FEC.RepairOverhead == "" && cfg.FEC.RepairSymbols > 0 {
+		cfg.FEC.RepairOverhead = strconv.FormatUint(uint64(cfg.FEC.RepairSymbols), 10)
+	}
+	if cfg.FEC.TargetSuccess == 0 {
+		cfg.FEC.TargetSuccess = 0.999
+	}
 	if cfg.Transmission.FdtDurationMs <= 0 {
 		cfg.Transmission.FdtDurationMs = 1000
 	}
+	if cfg.Transmission.FdtExpiresS <= 0 {
+		cfg.Transmission.FdtExpiresS = 3600
+	}
 	if cfg.Transmission.FdtStartID == 0 {
 		cfg.Transmission.FdtStartID = 1
 		fmt.Printf("FEC FdtStartID not set, using default %d\n", cfg.Transmission.FdtStartID)
@@ -0,0 +1,143 @@
+package fdt
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FDT 实例号为 20 位，按序号算术比较新旧（回绕后仍可比较）
+const (
+	instanceIDBits = 20
+	instanceIDMask = 1<<instanceIDBits - 1
+)
+
+// Database 为接收端的 FDT 数据库，按 TSI 保存各会话已接收的 FDT 实例，
+// 并将 TOI 解析为对应的文件描述
+type Database struct {
+	mu       sync.Mutex
+	sessions map[uint64]*sessionFDT
+}
+
+// sessionFDT 为一个会话（TSI）的 FDT 状态
+type sessionFDT struct {
+	instances map[uint32]time.Time // 已接收的实例号 -> 失效时间
+	files     map[uint64]fileEntry // TOI -> 文件描述
+	latest    uint32               // 已接收的最新实例号
+}
+
+// fileEntry 记录文件描述及其来源实例
+type fileEntry struct {
+	file       File
+	instanceID uint32
+	expires    time.Time
+}
+
+func NewDatabase() *Database {
+	return &Database{sessions: make(map[uint64]*sessionFDT)}
+}
+
+// newerInstance 判断实例号 a 是否比 b 新（RFC 1982 序号算术，20 位）
+func newerInstance(a, b uint32) bool {
+	diff := (a - b) & instanceIDMask
+	return diff != 0 && diff < 1<<(instanceIDBits-1)
+}
+
+// Has 判断会话是否已接收过该实例且尚未失效，用于丢弃重复发送的 FDT
+func (db *Database) Has(tsi uint64, instanceID uint32, now time.Time) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	session, ok := db.sessions[tsi]
+	if !ok {
+		return false
+	}
+	expires, ok := session.instances[instanceID]
+	return ok && now.Before(expires)
+}
+
+// Add 将一个 FDT 实例加入会话。已失效的实例返回错误；
+// 同一 TOI 由较新的实例描述时覆盖旧描述，较旧的实例只补充尚未知道的 TOI。
+// 返回本次新增或更新描述的 TOI
+func (db *Database) Add(tsi uint64, inst *Instance, now time.Time) ([]uint64, error) {
+	expires, err := inst.ExpiresAt()
+	if err != nil {
+		return nil, err
+	}
+	if !now.Before(expires) {
+		return nil, fmt.Errorf("FDT instance %d expired at %v", inst.InstanceID, expires)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	session, ok := db.sessions[tsi]
+	if !ok {
+		session = &sessionFDT{
+			instances: make(map[uint32]time.Time),
+			files:     make(map[uint64]fileEntry),
+			latest:    inst.InstanceID,
+		}
+		db.sessions[tsi] = session
+	}
+	session.instances[inst.InstanceID] = expires
+	if newerInstance(inst.InstanceID, session.latest) {
+		session.latest = inst.InstanceID
+	}
+
+	updated := make([]uint64, 0, len(inst.Files))
+	for _, file := range inst.Files {
+		toi, err := file.FileTOI()
+		if err != nil {
+			return updated, fmt.Errorf("FDT instance %d: %w", inst.InstanceID, err)
+		}
+		if toi == 0 {
+			return updated, fmt.Errorf("FDT instance %d describes reserved TOI 0", inst.InstanceID)
+		}
+		if old, exists := session.files[toi]; exists && now.Before(old.expires) &&
+			old.instanceID != inst.InstanceID && !newerInstance(inst.InstanceID, old.instanceID) {
+			continue
+		}
+		session.files[toi] = fileEntry{file: file, instanceID: inst.InstanceID, expires: expires}
+		updated = append(updated, toi)
+	}
+	return updated, nil
+}
+
+// Lookup 返回会话中 TOI 对应的文件描述及其来源实例号，描述不存在或已失效时返回 false
+func (db *Database) Lookup(tsi, toi uint64, now time.Time) (File, uint32, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	session, ok := db.sessions[tsi]
+	if !ok {
+		return File{}, 0, false
+	}
+	entry, ok := session.files[toi]
+	if !ok || !now.Before(entry.expires) {
+		return File{}, 0, false
+	}
+	return entry.file, entry.instanceID, true
+}
+
+// Expire 删除已失效的实例和文件描述，会话不再有任何实例时一并删除
+func (db *Database) Expire(now time.Time) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for tsi, session := range db.sessions {
+		for id, expires := range session.instances {
+			if !now.Before(expires) {
+				delete(session.instances, id)
+			}
+		}
+		for toi, entry := range session.files {
+			if !now.Before(entry.expires) {
+				delete(session.files, toi)
+			}
+		}
+		if len(session.instances) == 0 {
+			delete(db.sessions, tsi)
+		}
+	}
+}
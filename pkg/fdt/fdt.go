@@ -1,18 +1,194 @@
 package fdt
 
-import "encoding/json"
+import (
+	lct "FluteTest/pkg/lct"
+	oti "FluteTest/pkg/oti"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+)
 
-type ExtFDT struct {
-	FDTInstanceID uint32
-	ContentType   string
-	FileName      string
-	// Expires time.Time
+// FDT 实例的 XML 命名空间（RFC 6726 3.4.2）
+const Namespace = "urn:ietf:params:xml:ns:fdt"
 
-	// FecOtiMaximumSourceBlockLength uint16
-	// FecOtiEncodingSymbolLength uint16
+// 本项目扩展属性的命名空间，FDT Schema 允许其他命名空间的属性
+const ExtNamespace = "https://github.com/Mowenhao13/Flute_test/fdt-ext"
+
+// Instance 对应一个 FDT-Instance XML 文档，以 TOI 0 对象发送
+type Instance struct {
+	XMLName         xml.Name `xml:"urn:ietf:params:xml:ns:fdt FDT-Instance"`
+	Expires         string   `xml:"Expires,attr"` // NTP 秒数的十进制字符串
+	Complete        bool     `xml:"Complete,attr,omitempty"`
+	ContentType     string   `xml:"Content-Type,attr,omitempty"`
+	ContentEncoding string   `xml:"Content-Encoding,attr,omitempty"`
+	Files           []File   `xml:"File"`
+
+	// 以下字段不参与 XML 编码，由 EXT_FDT 携带
+	InstanceID uint32 `xml:"-"`
+}
+
+// File 描述 FDT 实例中的一个文件
+type File struct {
+	ContentLocation string `xml:"Content-Location,attr"`
+	TOI             string `xml:"TOI,attr"`
+	ContentLength   uint64 `xml:"Content-Length,attr,omitempty"`
+	TransferLength  uint64 `xml:"Transfer-Length,attr,omitempty"`
+	ContentType     string `xml:"Content-Type,attr,omitempty"`
+	ContentEncoding string `xml:"Content-Encoding,attr,omitempty"`
+	ContentMD5      string `xml:"Content-MD5,attr,omitempty"`                                                     // RFC 1864，摘要的 base64 编码
+	ContentSHA256   string `xml:"https://github.com/Mowenhao13/Flute_test/fdt-ext Content-SHA256,attr,omitempty"` // 可选，SHA-256 摘要的 base64 编码
+
+	FECEncodingID              *uint8  `xml:"FEC-OTI-FEC-Encoding-ID,attr,omitempty"`
+	FECInstanceID              *uint16 `xml:"FEC-OTI-FEC-Instance-ID,attr,omitempty"`
+	MaximumSourceBlockLength   uint32  `xml:"FEC-OTI-Maximum-Source-Block-Length,attr,omitempty"`
+	EncodingSymbolLength       uint16  `xml:"FEC-OTI-Encoding-Symbol-Length,attr,omitempty"`
+	MaxNumberOfEncodingSymbols uint32  `xml:"FEC-OTI-Max-Number-of-Encoding-Symbols,attr,omitempty"`
+	SchemeSpecificInfo         string  `xml:"FEC-OTI-Scheme-Specific-Info,attr,omitempty"` // base64
+}
+
+// NewInstance 创建一个在 expires 时刻失效的 FDT 实例
+func NewInstance(instanceID uint32, expires time.Time) *Instance {
+	return &Instance{
+		InstanceID: instanceID,
+		Expires:    strconv.FormatInt(expires.Unix()+lct.NTPEpochOffset, 10),
+	}
+}
+
+// ExpiresAt 将 Expires 属性还原为时间
+func (inst *Instance) ExpiresAt() (time.Time, error) {
+	ntp, err := strconv.ParseInt(inst.Expires, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid Expires %q: %w", inst.Expires, err)
+	}
+	return time.Unix(ntp-lct.NTPEpochOffset, 0), nil
+}
+
+// Marshal 将 FDT 实例编码为 XML 文档
+func (inst *Instance) Marshal() ([]byte, error) {
+	body, err := xml.MarshalIndent(inst, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal FDT instance: %w", err)
+	}
+	return append([]byte(xml.Header), body...), nil
 }
 
-// Marshal 将扩展 FDT 元数据编码为 JSON 字节流，便于通过 ALC 发送。
-func (f ExtFDT) Marshal() ([]byte, error) {
-	return json.Marshal(f)
+// Parse 解析 FDT-Instance XML 文档
+func Parse(data []byte) (*Instance, error) {
+	var inst Instance
+	if err := xml.Unmarshal(data, &inst); err != nil {
+		return nil, fmt.Errorf("parse FDT instance: %w", err)
+	}
+	if inst.Expires == "" {
+		return nil, fmt.Errorf("FDT instance missing Expires attribute")
+	}
+	return &inst, nil
+}
+
+// FileTOI 返回文件的 TOI
+func (f *File) FileTOI() (uint64, error) {
+	toi, err := strconv.ParseUint(f.TOI, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid TOI %q: %w", f.TOI, err)
+	}
+	return toi, nil
+}
+
+// Equal 判断两个文件描述的全部属性是否相同。FDT 实例更新（如轮播中续期）时
+// 描述不变的文件仍是同一个对象，接收端不需要重新接收
+func (f *File) Equal(other File) bool {
+	a, b := *f, other
+	if !equalPtr(a.FECEncodingID, b.FECEncodingID) || !equalPtr(a.FECInstanceID, b.FECInstanceID) {
+		return false
+	}
+	a.FECEncodingID, a.FECInstanceID = nil, nil
+	b.FECEncodingID, b.FECInstanceID = nil, nil
+	return a == b
+}
+
+func equalPtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// SetOTI 将对象的 OTI 写入文件的 FEC-OTI-* 属性
+func (f *File) SetOTI(o oti.Oti) {
+	encodingID := o.FECEncodingID
+	f.FECEncodingID = &encodingID
+	f.TransferLength = o.TransferLength
+	f.EncodingSymbolLength = o.EncodingSymbolLength
+	f.MaximumSourceBlockLength = o.MaximumSourceBlockLength
+	f.MaxNumberOfEncodingSymbols = o.MaxEncodingSymbols
+	switch o.FECEncodingID {
+	case oti.FECEncodingRaptorQ:
+		// RFC 6330 3.3.3: Z(8) | N(16) | Al(8)
+		info := make([]byte, 4)
+		info[0] = uint8(o.SourceBlocks)
+		binary.BigEndian.PutUint16(info[1:3], o.SubBlocks)
+		info[3] = o.SymbolAlignment
+		f.SchemeSpecificInfo = base64.StdEncoding.EncodeToString(info)
+	case oti.FECEncodingReedSolomon:
+		// RFC 5510 5.2.3: m(8) | G(8)
+		info := []byte{oti.ReedSolomonM, oti.ReedSolomonSymbolsPerPkt}
+		f.SchemeSpecificInfo = base64.StdEncoding.EncodeToString(info)
+	case oti.FECEncodingLDPCStaircase:
+		// RFC 5170: PRNG seed(32) | N1m3(8) | G(8)
+		info := make([]byte, 6)
+		binary.BigEndian.PutUint32(info[0:4], o.PRNGSeed)
+		info[4] = o.N1 - oti.LDPCMinN1
+		info[5] = oti.LDPCSymbolsPerPkt
+		f.SchemeSpecificInfo = base64.StdEncoding.EncodeToString(info)
+	}
+}
+
+// OTI 从文件的 FEC-OTI-* 属性还原 OTI，没有 FEC-OTI-FEC-Encoding-ID 时返回 false
+func (f *File) OTI() (oti.Oti, bool, error) {
+	if f.FECEncodingID == nil {
+		return oti.Oti{}, false, nil
+	}
+
+	o := oti.Oti{
+		FECEncodingID:            *f.FECEncodingID,
+		TransferLength:           f.TransferLength,
+		EncodingSymbolLength:     f.EncodingSymbolLength,
+		MaximumSourceBlockLength: f.MaximumSourceBlockLength,
+		MaxEncodingSymbols:       f.MaxNumberOfEncodingSymbols,
+	}
+	if f.FECInstanceID != nil {
+		o.FECInstanceID = *f.FECInstanceID
+	}
+	switch o.FECEncodingID {
+	case oti.FECEncodingRaptorQ:
+		info, err := base64.StdEncoding.DecodeString(f.SchemeSpecificInfo)
+		if err != nil || len(info) < 4 {
+			return o, true, fmt.Errorf("invalid RaptorQ scheme-specific info %q", f.SchemeSpecificInfo)
+		}
+		o.SourceBlocks = uint16(info[0])
+		o.SubBlocks = binary.BigEndian.Uint16(info[1:3])
+		o.SymbolAlignment = info[3]
+	case oti.FECEncodingReedSolomon:
+		// 未携带时 m 与 G 取 RFC 5510 的默认值 8 与 1
+		if f.SchemeSpecificInfo == "" {
+			break
+		}
+		info, err := base64.StdEncoding.DecodeString(f.SchemeSpecificInfo)
+		if err != nil || len(info) < 2 {
+			return o, true, fmt.Errorf("invalid Reed-Solomon scheme-specific info %q", f.SchemeSpecificInfo)
+		}
+		if info[0] != oti.ReedSolomonM || info[1] != oti.ReedSolomonSymbolsPerPkt {
+			return o, true, fmt.Errorf("unsupported Reed-Solomon parameters m=%d G=%d", info[0], info[1])
+		}
+	case oti.FECEncodingLDPCStaircase:
+		info, err := base64.StdEncoding.DecodeString(f.SchemeSpecificInfo)
+		if err != nil || len(info) < 6 || info[5] != oti.LDPCSymbolsPerPkt {
+			return o, true, fmt.Errorf("invalid LDPC-Staircase scheme-specific info %q", f.SchemeSpecificInfo)
+		}
+		o.PRNGSeed = binary.BigEndian.Uint32(info[0:4])
+		o.N1 = info[4] + oti.LDPCMinN1
+	}
+	return o, true, nil
 }
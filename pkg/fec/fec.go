@@ -0,0 +1,163 @@
+package fec
+
+import (
+	oti "FluteTest/pkg/oti"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// FECScheme 是一种 FEC 方案（RFC 5052），按 IANA 分配的 FEC Encoding ID 注册。
+// 方案只负责单个源块的编解码，源块划分由 OTI 决定
+type FECScheme interface {
+	// EncodingID 返回 IANA FEC Encoding ID，同时作为 LCT 头部的 Codepoint
+	EncodingID() uint8
+	// Name 返回方案名称，与配置文件中的 fec.type 对应
+	Name() string
+	// NewEncoder 为对象中的一个源块创建编码器，block 为源块的有效数据
+	NewEncoder(o oti.Oti, sb oti.SourceBlock, block []byte) (Encoder, error)
+	// NewDecoder 为对象中的一个源块创建解码器
+	NewDecoder(o oti.Oti, sb oti.SourceBlock) (Decoder, error)
+	// ReceptionOverhead 返回恢复 k 个源符号的源块通常需要在 k 之外多收到的编码符号数
+	ReceptionOverhead(k uint32) uint32
+
+	// PayloadIDLength 返回 FEC Payload ID 的字节数
+	PayloadIDLength() int
+	// MarshalPayloadID 编码 FEC Payload ID，SBN 或 ESI 超出字段宽度时返回错误
+	MarshalPayloadID(sbn, esi uint32) ([]byte, error)
+	// UnmarshalPayloadID 解析 FEC Payload ID
+	UnmarshalPayloadID(data []byte) (sbn, esi uint32, err error)
+}
+
+// Encoder 生成一个源块的编码符号
+type Encoder interface {
+	// GenSymbol 生成 ESI 对应的编码符号，ESI < K 为源符号，其余为修复符号
+	GenSymbol(esi uint32) ([]byte, error)
+	// MaxRepairSymbols 返回可生成的修复符号数上限，不支持修复符号时为 0
+	MaxRepairSymbols() uint32
+}
+
+// Decoder 由接收到的编码符号恢复一个源块
+type Decoder interface {
+	// AddSymbol 加入一个编码符号，源块恢复后返回 true
+	AddSymbol(esi uint32, symbol []byte) (bool, error)
+	// Data 返回恢复的源块数据（长度为源块的有效字节数），尚未恢复时返回 nil
+	Data() []byte
+}
+
+// payloadID 为由 SBN 和 ESI 两个定长字段组成的 FEC Payload ID（RFC 5052 3.2），
+// 各方案只需给出两个字段的位宽
+type payloadID struct {
+	sbnBits uint
+	esiBits uint
+}
+
+func (p payloadID) PayloadIDLength() int { return int(p.sbnBits+p.esiBits) / 8 }
+
+func (p payloadID) MarshalPayloadID(sbn, esi uint32) ([]byte, error) {
+	if uint64(sbn) >= 1<<p.sbnBits {
+		return nil, fmt.Errorf("SBN %d exceeds %d bits", sbn, p.sbnBits)
+	}
+	if uint64(esi) >= 1<<p.esiBits {
+		return nil, fmt.Errorf("ESI %d exceeds %d bits", esi, p.esiBits)
+	}
+	v := uint64(sbn)<<p.esiBits | uint64(esi)
+	data := make([]byte, p.PayloadIDLength())
+	for i := len(data) - 1; i >= 0; i-- {
+		data[i] = byte(v)
+		v >>= 8
+	}
+	return data, nil
+}
+
+func (p payloadID) UnmarshalPayloadID(data []byte) (uint32, uint32, error) {
+	n := p.PayloadIDLength()
+	if len(data) < n {
+		return 0, 0, fmt.Errorf("FEC payload ID too short: %d, need %d", len(data), n)
+	}
+	var v uint64
+	for _, b := range data[:n] {
+		v = v<<8 | uint64(b)
+	}
+	return uint32(v >> p.esiBits), uint32(v & (1<<p.esiBits - 1)), nil
+}
+
+// encodingSymbols 按 RFC 5510 8 / RFC 5170 计算 k 个源符号的源块的编码符号数 n = floor(k * max_n / B)，
+// 使各源块的码率与完整源块一致
+func encodingSymbols(o oti.Oti, k uint32, limit uint64) (uint32, error) {
+	B := uint64(o.MaximumSourceBlockLength)
+	if B == 0 || uint64(k) > B {
+		return 0, fmt.Errorf("invalid source block length %d (B=%d)", k, B)
+	}
+	n := uint64(k) * uint64(o.MaxEncodingSymbols) / B
+	if n < uint64(k) || n > limit {
+		return 0, fmt.Errorf("invalid encoding symbol count %d for k=%d (B=%d, max_n=%d)", n, k, B, o.MaxEncodingSymbols)
+	}
+	return uint32(n), nil
+}
+
+// splitSymbols 将源块按 E 切分为 k 个源符号，最后一个源符号补零
+func splitSymbols(block []byte, k, E int) [][]byte {
+	source := make([][]byte, k)
+	for i := range source {
+		source[i] = make([]byte, E)
+		start := i * E
+		if start < len(block) {
+			copy(source[i], block[start:min(start+E, len(block))])
+		}
+	}
+	return source
+}
+
+// SourceSymbolRange 返回源符号 esi 在源块中的字节范围 [start, end)。源符号即源块中连续 E 字节的方案
+// （No-Code、Reed-Solomon、LDPC-Staircase 以及不划分子块的 RaptorQ）返回 true，
+// 接收端可以不经解码器直接写入收到的源符号
+func SourceSymbolRange(o oti.Oti, sb oti.SourceBlock, esi uint32) (uint64, uint64, bool) {
+	if esi >= sb.Symbols || (o.FECEncodingID == oti.FECEncodingRaptorQ && o.SubBlocks > 1) {
+		return 0, 0, false
+	}
+	start := uint64(esi) * uint64(o.EncodingSymbolLength)
+	if start >= sb.Length {
+		return 0, 0, false
+	}
+	return start, min(start+uint64(o.EncodingSymbolLength), sb.Length), true
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[uint8]FECScheme{}
+)
+
+// Register 按 FEC Encoding ID 注册方案，重复注册会覆盖之前的方案
+func Register(scheme FECScheme) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[scheme.EncodingID()] = scheme
+}
+
+// Lookup 返回 FEC Encoding ID 对应的方案
+func Lookup(encodingID uint8) (FECScheme, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	scheme, ok := registry[encodingID]
+	return scheme, ok
+}
+
+// LookupName 按名称（不区分大小写）返回方案
+func LookupName(name string) (FECScheme, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, scheme := range registry {
+		if strings.EqualFold(scheme.Name(), name) {
+			return scheme, true
+		}
+	}
+	return nil, false
+}
+
+func init() {
+	Register(noCodeScheme{payloadID{sbnBits: 16, esiBits: 16}})
+	Register(ldpcStaircaseScheme{payloadID{sbnBits: 12, esiBits: 20}})
+	Register(reedSolomonScheme{payloadID{sbnBits: 24, esiBits: 8}})
+	Register(raptorQScheme{payloadID{sbnBits: 8, esiBits: 24}})
+}
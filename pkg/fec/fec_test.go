@@ -0,0 +1,115 @@
+package fec
+
+import (
+	oti "FluteTest/pkg/oti"
+	"bytes"
+	"math/rand/v2"
+	"testing"
+)
+
+// payloadIDCase 为一个 FEC Payload ID 用例，err 为 true 时 SBN 或 ESI 超出字段宽度
+type payloadIDCase struct {
+	sbn, esi uint32
+	wire     []byte
+	err      bool
+}
+
+// checkPayloadIDs 检查方案的 Payload ID 编码结果与 wire 一致且能解析回原值，超出字段宽度时返回错误
+func checkPayloadIDs(t *testing.T, scheme FECScheme, cases []payloadIDCase) {
+	t.Helper()
+	for _, c := range cases {
+		data, err := scheme.MarshalPayloadID(c.sbn, c.esi)
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: SBN %d ESI %d encoded as %x, want error", scheme.Name(), c.sbn, c.esi, data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: SBN %d ESI %d: %v", scheme.Name(), c.sbn, c.esi, err)
+			continue
+		}
+		if !bytes.Equal(data, c.wire) || len(data) != scheme.PayloadIDLength() {
+			t.Errorf("%s: SBN %d ESI %d encoded as %x, want %x", scheme.Name(), c.sbn, c.esi, data, c.wire)
+		}
+		sbn, esi, err := scheme.UnmarshalPayloadID(data)
+		if err != nil || sbn != c.sbn || esi != c.esi {
+			t.Errorf("%s: %x parsed as SBN %d ESI %d (%v), want SBN %d ESI %d", scheme.Name(), data, sbn, esi, err, c.sbn, c.esi)
+		}
+	}
+	if _, _, err := scheme.UnmarshalPayloadID(make([]byte, scheme.PayloadIDLength()-1)); err == nil {
+		t.Errorf("%s: truncated payload ID parsed without error", scheme.Name())
+	}
+}
+
+// testObject 为 o 设置传输长度，返回源块划分与伪随机的对象数据
+func testObject(t *testing.T, o oti.Oti, length uint64) (oti.Oti, []oti.SourceBlock, []byte) {
+	t.Helper()
+	o, err := o.WithTransferLength(length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := o.Partition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, length)
+	rng := rand.New(rand.NewPCG(length, uint64(o.FECEncodingID)))
+	for i := range data {
+		data[i] = byte(rng.Uint32())
+	}
+	return o, blocks, data
+}
+
+// maxTestRepairSymbols 限制 encodeBlock 生成的修复符号数，RaptorQ 可生成的修复符号多达 2^24 个
+const maxTestRepairSymbols = 1024
+
+// encodeBlock 返回源块的全部编码符号（源符号与编码器能生成的修复符号，至多 maxTestRepairSymbols 个），按 ESI 排列
+func encodeBlock(t *testing.T, scheme FECScheme, o oti.Oti, sb oti.SourceBlock, data []byte) [][]byte {
+	t.Helper()
+	encoder, err := scheme.NewEncoder(o, sb, data[sb.Offset:sb.Offset+sb.Length])
+	if err != nil {
+		t.Fatal(err)
+	}
+	maxRepair := encoder.MaxRepairSymbols()
+	n := sb.Symbols + min(maxRepair, maxTestRepairSymbols)
+	symbols := make([][]byte, n)
+	for esi := range n {
+		if symbols[esi], err = encoder.GenSymbol(esi); err != nil {
+			t.Fatalf("block %d ESI %d: %v", sb.SBN, esi, err)
+		}
+	}
+	if maxRepair > maxTestRepairSymbols {
+		return symbols
+	}
+	if _, err := encoder.GenSymbol(n); err == nil {
+		t.Fatalf("block %d: ESI %d beyond n=%d generated without error", sb.SBN, n, n)
+	}
+	return symbols
+}
+
+// decodeBlock 按 esis 的顺序把编码符号交给新的解码器，返回源块是否恢复
+func decodeBlock(t *testing.T, scheme FECScheme, o oti.Oti, sb oti.SourceBlock, symbols [][]byte, esis []uint32) ([]byte, bool) {
+	t.Helper()
+	decoder, err := scheme.NewDecoder(o, sb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := false
+	for _, esi := range esis {
+		if done, err = decoder.AddSymbol(esi, symbols[esi]); err != nil {
+			t.Fatalf("block %d ESI %d: %v", sb.SBN, esi, err)
+		}
+	}
+	return decoder.Data(), done
+}
+
+// shuffledESIs 返回 0..n-1 的一个随机排列
+func shuffledESIs(rng *rand.Rand, n uint32) []uint32 {
+	esis := make([]uint32, n)
+	for i := range esis {
+		esis[i] = uint32(i)
+	}
+	rng.Shuffle(len(esis), func(i, j int) { esis[i], esis[j] = esis[j], esis[i] })
+	return esis
+}
@@ -0,0 +1,104 @@
+package fec
+
+import "fmt"
+
+// GF(2^8) 运算，本原多项式 x^8 + x^4 + x^3 + x^2 + 1（RFC 5510 8.1），本原元 α = 2
+const gfPoly = 0x11D
+
+var (
+	gfExp [510]byte // α^i，长度翻倍以免乘法时取模
+	gfLog [256]byte
+	gfMul [256][256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfExp[i+255] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= gfPoly
+		}
+	}
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			gfMul[a][b] = gfExp[int(gfLog[a])+int(gfLog[b])]
+		}
+	}
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// gfPow 返回 α^e
+func gfPow(e int) byte {
+	return gfExp[e%255]
+}
+
+// gfMulAdd 计算 dst ^= c * src
+func gfMulAdd(dst, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	row := &gfMul[c]
+	for i, b := range src {
+		dst[i] ^= row[b]
+	}
+}
+
+// gfMatrix 为 GF(2^8) 上的方阵
+type gfMatrix [][]byte
+
+func newGFMatrix(n int) gfMatrix {
+	m := make(gfMatrix, n)
+	for i := range m {
+		m[i] = make([]byte, n)
+	}
+	return m
+}
+
+// invert 以高斯-约旦消元求逆矩阵，不修改 m
+func (m gfMatrix) invert() (gfMatrix, error) {
+	n := len(m)
+	work := newGFMatrix(n)
+	inv := newGFMatrix(n)
+	for i := range m {
+		copy(work[i], m[i])
+		inv[i][i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := -1
+		for row := col; row < n; row++ {
+			if work[row][col] != 0 {
+				pivot = row
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, fmt.Errorf("singular matrix")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+		inv[col], inv[pivot] = inv[pivot], inv[col]
+
+		if c := work[col][col]; c != 1 {
+			scale := gfInv(c)
+			for j := 0; j < n; j++ {
+				work[col][j] = gfMul[scale][work[col][j]]
+				inv[col][j] = gfMul[scale][inv[col][j]]
+			}
+		}
+		for row := 0; row < n; row++ {
+			if row == col || work[row][col] == 0 {
+				continue
+			}
+			c := work[row][col]
+			gfMulAdd(work[row], work[col], c)
+			gfMulAdd(inv[row], inv[col], c)
+		}
+	}
+	return inv, nil
+}
@@ -0,0 +1,373 @@
+package fec
+
+import (
+	oti "FluteTest/pkg/oti"
+	"crypto/subtle"
+	"fmt"
+)
+
+// ldpcStaircaseScheme 为 RFC 5170 的 LDPC-Staircase 码。校验矩阵 H = [H1 | H2] 共 m = n - k 行，
+// H1 由 PRNG 按每列 N1 个 "1" 均匀生成，H2 为阶梯矩阵，修复符号 p_i = p_(i-1) ^ (H1 第 i 行的源符号之和)，
+// 编码只需线性时间。解码先做迭代解码，卡住时对剩余未知符号做 GF(2) 高斯消元（ML 解码）。
+// FEC Payload ID 为 SBN(12) | ESI(20)
+type ldpcStaircaseScheme struct {
+	payloadID
+}
+
+func (ldpcStaircaseScheme) EncodingID() uint8 { return oti.FECEncodingLDPCStaircase }
+func (ldpcStaircaseScheme) Name() string      { return "LDPCStaircase" }
+
+// ReceptionOverhead 按约 5% 的接收冗余估计，迭代解码与 ML 解码一般在此范围内即可恢复
+func (ldpcStaircaseScheme) ReceptionOverhead(k uint32) uint32 { return k/20 + 1 }
+
+// parkMiller 为 RFC 5170 使用的 Park-Miller "minimal standard" 伪随机数发生器
+type parkMiller struct {
+	state uint32
+}
+
+func (p *parkMiller) next() uint32 {
+	p.state = uint32(uint64(p.state) * 16807 % 0x7FFFFFFF)
+	return p.state
+}
+
+// rand 返回 [0, maxv) 内的随机数
+func (p *parkMiller) rand(maxv uint32) uint32 {
+	return uint32(uint64(p.next()) * uint64(maxv) / 0x7FFFFFFF)
+}
+
+// ldpcMatrix 为 H1 的稀疏表示，rows[i] 为第 i 行中 "1" 所在的源符号列
+type ldpcMatrix struct {
+	k, m int
+	rows [][]uint32
+}
+
+// newLDPCMatrix 按 RFC 5170 的方法生成 H1：先让 "1" 在各行间均匀分布，每列 N1 个，
+// 再为不足两个 "1" 的行补充随机列
+func newLDPCMatrix(k, m, n1 int, seed uint32) *ldpcMatrix {
+	h := &ldpcMatrix{k: k, m: m, rows: make([][]uint32, m)}
+	if m == 0 {
+		return h
+	}
+	n1 = min(n1, m)
+
+	cols := make([][]uint32, k)
+	has := func(row, col int) bool {
+		for _, r := range cols[col] {
+			if int(r) == row {
+				return true
+			}
+		}
+		return false
+	}
+	insert := func(row, col int) {
+		cols[col] = append(cols[col], uint32(row))
+		h.rows[row] = append(h.rows[row], uint32(col))
+	}
+
+	prng := parkMiller{state: seed}
+	total := n1 * k
+	u := make([]uint32, total) // 尚未使用的行号，每行出现的次数相同
+	for i := range u {
+		u[i] = uint32(i % m)
+	}
+	t := 0
+	for j := 0; j < k; j++ {
+		for l := 0; l < n1; l++ {
+			i := t
+			for i < total && has(int(u[i]), j) {
+				i++
+			}
+			if i < total {
+				for {
+					i = t + int(prng.rand(uint32(total-t)))
+					if !has(int(u[i]), j) {
+						break
+					}
+				}
+				insert(int(u[i]), j)
+				u[i] = u[t]
+				t++
+			} else {
+				// 剩余的行号都已在本列中，随机选择一行
+				for {
+					i = int(prng.rand(uint32(m)))
+					if !has(i, j) {
+						break
+					}
+				}
+				insert(i, j)
+			}
+		}
+	}
+
+	for i := 0; i < m; i++ {
+		if len(h.rows[i]) == 0 {
+			insert(i, int(prng.rand(uint32(k))))
+		}
+		if len(h.rows[i]) == 1 && k > 1 {
+			for {
+				j := int(prng.rand(uint32(k)))
+				if !has(i, j) {
+					insert(i, j)
+					break
+				}
+			}
+		}
+	}
+	return h
+}
+
+func (ldpcStaircaseScheme) NewEncoder(o oti.Oti, sb oti.SourceBlock, block []byte) (Encoder, error) {
+	n, err := encodingSymbols(o, sb.Symbols, oti.LDPCMaxEncSymbols)
+	if err != nil {
+		return nil, err
+	}
+	k := int(sb.Symbols)
+	return &ldpcStaircaseEncoder{
+		source: splitSymbols(block, k, int(o.EncodingSymbolLength)),
+		h:      newLDPCMatrix(k, int(n)-k, int(o.N1), o.PRNGSeed),
+	}, nil
+}
+
+func (ldpcStaircaseScheme) NewDecoder(o oti.Oti, sb oti.SourceBlock) (Decoder, error) {
+	n, err := encodingSymbols(o, sb.Symbols, oti.LDPCMaxEncSymbols)
+	if err != nil {
+		return nil, err
+	}
+	k := int(sb.Symbols)
+	h := newLDPCMatrix(k, int(n)-k, int(o.N1), o.PRNGSeed)
+
+	d := &ldpcStaircaseDecoder{
+		block:      sb,
+		k:          k,
+		n:          int(n),
+		symbolSize: int(o.EncodingSymbolLength),
+		rowVars:    make([][]uint32, h.m),
+		varRows:    make([][]uint32, n),
+		values:     make([][]byte, n),
+		rowSum:     make([][]byte, h.m),
+		rowUnknown: make([]int, h.m),
+		nextML:     k,
+	}
+	// 第 i 个方程：H1 第 i 行的源符号 ^ p_i ^ p_(i-1) = 0，p_i 的 ESI 为 k + i
+	for i, cols := range h.rows {
+		vars := append([]uint32(nil), cols...)
+		vars = append(vars, uint32(k+i))
+		if i > 0 {
+			vars = append(vars, uint32(k+i-1))
+		}
+		d.rowVars[i] = vars
+		d.rowUnknown[i] = len(vars)
+		for _, v := range vars {
+			d.varRows[v] = append(d.varRows[v], uint32(i))
+		}
+	}
+	return d, nil
+}
+
+type ldpcStaircaseEncoder struct {
+	source [][]byte
+	h      *ldpcMatrix
+	parity [][]byte // 已计算的修复符号，按 ESI 顺序递推
+}
+
+func (e *ldpcStaircaseEncoder) GenSymbol(esi uint32) ([]byte, error) {
+	k := uint32(len(e.source))
+	if esi < k {
+		return e.source[esi], nil
+	}
+	idx := int(esi - k)
+	if idx >= e.h.m {
+		return nil, fmt.Errorf("ESI %d out of range (n=%d)", esi, e.h.k+e.h.m)
+	}
+
+	for len(e.parity) <= idx {
+		i := len(e.parity)
+		p := make([]byte, len(e.source[0]))
+		if i > 0 {
+			copy(p, e.parity[i-1])
+		}
+		for _, col := range e.h.rows[i] {
+			subtle.XORBytes(p, p, e.source[col])
+		}
+		e.parity = append(e.parity, p)
+	}
+	return e.parity[idx], nil
+}
+
+func (e *ldpcStaircaseEncoder) MaxRepairSymbols() uint32 { return uint32(e.h.m) }
+
+type ldpcStaircaseDecoder struct {
+	block      oti.SourceBlock
+	k, n       int
+	symbolSize int
+
+	rowVars    [][]uint32 // 每个方程涉及的编码符号 ESI
+	varRows    [][]uint32 // 每个编码符号所在的方程
+	values     [][]byte   // ESI -> 已知的编码符号（收到或解出，已补齐到 E 字节）
+	rowSum     [][]byte   // 每个方程中已知符号的异或和
+	rowUnknown []int      // 每个方程中未知符号的个数，0 表示方程已用尽
+
+	knownSource int
+	received    int
+	nextML      int // 收到的符号数达到该值时尝试 ML 解码
+	data        []byte
+}
+
+// AddSymbol 加入一个编码符号并做迭代解码，收到至少 k 个符号后仍未恢复时周期性地尝试 ML 解码
+func (d *ldpcStaircaseDecoder) AddSymbol(esi uint32, symbol []byte) (bool, error) {
+	if d.data != nil {
+		return true, nil
+	}
+	if int(esi) >= d.n {
+		return false, fmt.Errorf("ESI %d out of range (n=%d)", esi, d.n)
+	}
+	if len(symbol) > d.symbolSize || (len(symbol) < d.symbolSize && esi != d.block.Symbols-1) {
+		return false, fmt.Errorf("incorrect symbol size %d, should be %d", len(symbol), d.symbolSize)
+	}
+	if d.values[esi] != nil {
+		return false, nil
+	}
+
+	padded := make([]byte, d.symbolSize)
+	copy(padded, symbol)
+	d.received++
+	d.learn(esi, padded)
+
+	if d.knownSource < d.k && d.received >= d.nextML {
+		d.nextML = d.received + max(1, d.k/100)
+		d.solveML()
+	}
+	if d.knownSource < d.k {
+		return false, nil
+	}
+
+	d.data = make([]byte, 0, d.k*d.symbolSize)
+	for _, s := range d.values[:d.k] {
+		d.data = append(d.data, s...)
+	}
+	d.data = d.data[:d.block.Length]
+	d.rowVars, d.varRows, d.values, d.rowSum, d.rowUnknown = nil, nil, nil, nil, nil
+	return true, nil
+}
+
+// learn 记录一个已知符号，并不断用只剩一个未知符号的方程解出新的符号
+func (d *ldpcStaircaseDecoder) learn(esi uint32, value []byte) {
+	var ready []uint32
+	d.setKnown(esi, value, &ready)
+	for len(ready) > 0 {
+		r := ready[len(ready)-1]
+		ready = ready[:len(ready)-1]
+		if d.rowUnknown[r] != 1 {
+			continue
+		}
+		for _, v := range d.rowVars[r] {
+			if d.values[v] != nil {
+				continue
+			}
+			// 方程中其余符号的异或和即为该未知符号
+			solved := d.rowSum[r]
+			if solved == nil {
+				solved = make([]byte, d.symbolSize)
+			}
+			d.rowSum[r] = nil
+			d.rowUnknown[r] = 0
+			d.setKnown(v, solved, &ready)
+			break
+		}
+	}
+}
+
+func (d *ldpcStaircaseDecoder) setKnown(esi uint32, value []byte, ready *[]uint32) {
+	d.values[esi] = value
+	if int(esi) < d.k {
+		d.knownSource++
+	}
+	for _, r := range d.varRows[esi] {
+		if d.rowUnknown[r] == 0 {
+			continue
+		}
+		if d.rowSum[r] == nil {
+			d.rowSum[r] = make([]byte, d.symbolSize)
+		}
+		subtle.XORBytes(d.rowSum[r], d.rowSum[r], value)
+		d.rowUnknown[r]--
+		switch d.rowUnknown[r] {
+		case 1:
+			*ready = append(*ready, r)
+		case 0:
+			d.rowSum[r] = nil
+		}
+	}
+}
+
+// solveML 对剩余的未知符号做 GF(2) 高斯-约旦消元，全部可解时写入 values
+func (d *ldpcStaircaseDecoder) solveML() {
+	column := make(map[uint32]int)
+	var unknown []uint32
+	for v := range d.values {
+		if d.values[v] == nil {
+			column[uint32(v)] = len(unknown)
+			unknown = append(unknown, uint32(v))
+		}
+	}
+	var rows []int
+	for r, cnt := range d.rowUnknown {
+		if cnt > 0 {
+			rows = append(rows, r)
+		}
+	}
+	if len(rows) < len(unknown) {
+		return
+	}
+
+	words := (len(unknown) + 63) / 64
+	bits := make([][]uint64, len(rows))
+	rhs := make([][]byte, len(rows))
+	for i, r := range rows {
+		bits[i] = make([]uint64, words)
+		for _, v := range d.rowVars[r] {
+			if c, ok := column[v]; ok {
+				bits[i][c/64] |= 1 << (c % 64)
+			}
+		}
+		rhs[i] = make([]byte, d.symbolSize)
+		if d.rowSum[r] != nil {
+			copy(rhs[i], d.rowSum[r])
+		}
+	}
+
+	for c := range unknown {
+		w, b := c/64, uint64(1)<<(c%64)
+		pivot := -1
+		for i := c; i < len(rows); i++ {
+			if bits[i][w]&b != 0 {
+				pivot = i
+				break
+			}
+		}
+		if pivot < 0 {
+			return // 秩不足，等待更多符号
+		}
+		bits[c], bits[pivot] = bits[pivot], bits[c]
+		rhs[c], rhs[pivot] = rhs[pivot], rhs[c]
+		for i := range rows {
+			if i == c || bits[i][w]&b == 0 {
+				continue
+			}
+			for x := w; x < words; x++ {
+				bits[i][x] ^= bits[c][x]
+			}
+			subtle.XORBytes(rhs[i], rhs[i], rhs[c])
+		}
+	}
+
+	for c, v := range unknown {
+		d.values[v] = rhs[c]
+		if int(v) < d.k {
+			d.knownSource++
+		}
+	}
+}
+
+func (d *ldpcStaircaseDecoder) Data() []byte { return d.data }
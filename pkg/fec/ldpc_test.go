@@ -0,0 +1,81 @@
+package fec
+
+import (
+	oti "FluteTest/pkg/oti"
+	"bytes"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+func TestLDPCStaircasePayloadID(t *testing.T) {
+	scheme, _ := Lookup(oti.FECEncodingLDPCStaircase)
+	checkPayloadIDs(t, scheme, []payloadIDCase{
+		{sbn: 0, esi: 0, wire: []byte{0, 0, 0, 0}},
+		{sbn: 1, esi: 2, wire: []byte{0, 0x10, 0, 2}},
+		{sbn: 0xabc, esi: 0xfffff, wire: []byte{0xab, 0xcf, 0xff, 0xff}},
+		{sbn: 0xfff, esi: 0x12345, wire: []byte{0xff, 0xf1, 0x23, 0x45}},
+		{sbn: 1 << 12, esi: 0, err: true},
+		{sbn: 0, esi: 1 << 20, err: true},
+	})
+}
+
+// TestLDPCStaircaseErasures 丢失 n-k 个编码符号：一个源符号 s 以及 p_0 以外的全部修复符号。
+// H1 第 0 行的方程为 p_0 与该行源符号之和为零，s 在第 0 行时可以由它解出；
+// s 不在第 0 行时其余方程都含有未知的修复符号，收到 k 个符号也无法恢复源块
+func TestLDPCStaircaseErasures(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		B, repair uint32
+		length    uint64
+	}{
+		{"small block", 10, 5, 10 * 16},
+		{"rate 1/2", 64, 64, 64*16 - 9},
+		{"several blocks", 100, 30, 250*16 + 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			scheme, _ := Lookup(oti.FECEncodingLDPCStaircase)
+			o, blocks, data := testObject(t, oti.NewLDPCStaircase(16, tc.B, tc.repair, 3, 1), tc.length)
+			rng := rand.New(rand.NewPCG(3, tc.length))
+			for _, sb := range blocks {
+				symbols := encodeBlock(t, scheme, o, sb, data)
+				n, k := uint32(len(symbols)), sb.Symbols
+				want := data[sb.Offset : sb.Offset+sb.Length]
+
+				// 全部编码符号以任意顺序到达
+				if got, ok := decodeBlock(t, scheme, o, sb, symbols, shuffledESIs(rng, n)); !ok || !bytes.Equal(got, want) {
+					t.Fatalf("block %d not recovered from all %d symbols", sb.SBN, n)
+				}
+
+				h := newLDPCMatrix(int(k), int(n-k), int(o.N1), o.PRNGSeed)
+				decodable, undecodable := false, false
+				for s := range k {
+					received := make([]uint32, 0, k)
+					for esi := range k {
+						if esi != s {
+							received = append(received, esi)
+						}
+					}
+					received = append(received, k) // p_0
+					rng.Shuffle(len(received), func(i, j int) { received[i], received[j] = received[j], received[i] })
+
+					got, ok := decodeBlock(t, scheme, o, sb, symbols, received)
+					if slices.Contains(h.rows[0], s) {
+						if !ok || !bytes.Equal(got, want) {
+							t.Fatalf("block %d: source symbol %d in row 0 not recovered from p_0", sb.SBN, s)
+						}
+						decodable = true
+					} else {
+						if ok {
+							t.Fatalf("block %d: source symbol %d outside row 0 recovered without the other repair symbols", sb.SBN, s)
+						}
+						undecodable = true
+					}
+				}
+				if !decodable || !undecodable {
+					t.Fatalf("block %d: row 0 covers %d of %d source symbols, both cases must occur", sb.SBN, len(h.rows[0]), k)
+				}
+			}
+		})
+	}
+}
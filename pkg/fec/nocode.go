@@ -0,0 +1,94 @@
+package fec
+
+import (
+	oti "FluteTest/pkg/oti"
+	"fmt"
+)
+
+// noCodeScheme 为 Compact No-Code（RFC 5445）：不做编码，编码符号即源符号，
+// 最后一个源符号可以短于 E。FEC Payload ID 为 SBN(16) | ESI(16)
+type noCodeScheme struct {
+	payloadID
+}
+
+func (noCodeScheme) EncodingID() uint8 { return oti.FECEncodingNoCode }
+func (noCodeScheme) Name() string      { return "no-code" }
+
+func (noCodeScheme) ReceptionOverhead(uint32) uint32 { return 0 }
+
+func (noCodeScheme) NewEncoder(o oti.Oti, sb oti.SourceBlock, block []byte) (Encoder, error) {
+	if uint64(len(block)) != sb.Length {
+		return nil, fmt.Errorf("source block %d has %d bytes, expected %d", sb.SBN, len(block), sb.Length)
+	}
+	return &noCodeEncoder{block: block, symbols: sb.Symbols, symbolSize: uint64(o.EncodingSymbolLength)}, nil
+}
+
+func (noCodeScheme) NewDecoder(o oti.Oti, sb oti.SourceBlock) (Decoder, error) {
+	if sb.Symbols == 0 {
+		return nil, fmt.Errorf("source block %d has no symbols", sb.SBN)
+	}
+	return &noCodeDecoder{
+		block:      sb,
+		symbolSize: uint64(o.EncodingSymbolLength),
+		symbols:    make([][]byte, sb.Symbols),
+	}, nil
+}
+
+type noCodeEncoder struct {
+	block      []byte
+	symbols    uint32
+	symbolSize uint64
+}
+
+func (e *noCodeEncoder) GenSymbol(esi uint32) ([]byte, error) {
+	if esi >= e.symbols {
+		return nil, fmt.Errorf("no-code has no repair symbols, ESI %d >= K %d", esi, e.symbols)
+	}
+	start := uint64(esi) * e.symbolSize
+	end := min(start+e.symbolSize, uint64(len(e.block)))
+	return e.block[start:end], nil
+}
+
+func (e *noCodeEncoder) MaxRepairSymbols() uint32 { return 0 }
+
+type noCodeDecoder struct {
+	block      oti.SourceBlock
+	symbolSize uint64
+	symbols    [][]byte
+	received   uint32
+	data       []byte
+}
+
+func (d *noCodeDecoder) AddSymbol(esi uint32, symbol []byte) (bool, error) {
+	if d.data != nil {
+		return true, nil
+	}
+	if esi >= d.block.Symbols {
+		return false, fmt.Errorf("ESI %d out of range (K=%d)", esi, d.block.Symbols)
+	}
+	expected := d.symbolSize
+	if esi == d.block.Symbols-1 {
+		expected = d.block.Length - uint64(esi)*d.symbolSize
+	}
+	if uint64(len(symbol)) != expected {
+		return false, fmt.Errorf("incorrect symbol size %d for ESI %d, should be %d", len(symbol), esi, expected)
+	}
+	if d.symbols[esi] != nil {
+		return false, nil
+	}
+
+	d.symbols[esi] = symbol
+	d.received++
+	if d.received < d.block.Symbols {
+		return false, nil
+	}
+
+	d.data = make([]byte, 0, d.block.Length)
+	for _, s := range d.symbols {
+		d.data = append(d.data, s...)
+	}
+	d.symbols = nil
+	return true, nil
+}
+
+func (d *noCodeDecoder) Data() []byte { return d.data }
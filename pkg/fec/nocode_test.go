@@ -0,0 +1,55 @@
+package fec
+
+import (
+	oti "FluteTest/pkg/oti"
+	"bytes"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestNoCodePayloadID(t *testing.T) {
+	scheme, _ := Lookup(oti.FECEncodingNoCode)
+	checkPayloadIDs(t, scheme, []payloadIDCase{
+		{sbn: 0, esi: 0, wire: []byte{0, 0, 0, 0}},
+		{sbn: 1, esi: 2, wire: []byte{0, 1, 0, 2}},
+		{sbn: 0x1234, esi: 0xabcd, wire: []byte{0x12, 0x34, 0xab, 0xcd}},
+		{sbn: 0xffff, esi: 0xffff, wire: []byte{0xff, 0xff, 0xff, 0xff}},
+		{sbn: 0x10000, esi: 0, err: true},
+		{sbn: 0, esi: 0x10000, err: true},
+	})
+}
+
+// TestNoCodeRoundTrip 源符号以任意顺序（含重复）到达时恢复源块，缺少任一源符号则无法恢复
+func TestNoCodeRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		B      uint32
+		length uint64
+	}{
+		{"one symbol", 16, 10},
+		{"exact symbols", 16, 64 * 16},
+		{"short last symbol", 16, 64*16 + 5},
+		{"several blocks", 8, 100*64 + 33},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			scheme, _ := Lookup(oti.FECEncodingNoCode)
+			o, blocks, data := testObject(t, oti.NewNoCode(64, tc.B), tc.length)
+			rng := rand.New(rand.NewPCG(1, tc.length))
+			for _, sb := range blocks {
+				symbols := encodeBlock(t, scheme, o, sb, data)
+				if uint32(len(symbols)) != sb.Symbols {
+					t.Fatalf("block %d: %d encoding symbols, want K=%d", sb.SBN, len(symbols), sb.Symbols)
+				}
+
+				esis := shuffledESIs(rng, sb.Symbols)
+				got, ok := decodeBlock(t, scheme, o, sb, symbols, append(esis[:1:1], esis...))
+				if !ok || !bytes.Equal(got, data[sb.Offset:sb.Offset+sb.Length]) {
+					t.Fatalf("block %d not recovered from all %d source symbols", sb.SBN, sb.Symbols)
+				}
+				if _, ok := decodeBlock(t, scheme, o, sb, symbols, esis[1:]); ok {
+					t.Fatalf("block %d recovered with source symbol %d missing", sb.SBN, esis[0])
+				}
+			}
+		})
+	}
+}
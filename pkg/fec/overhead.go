@@ -0,0 +1,119 @@
+package fec
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// RepairOverhead 描述每个源块在 K 个源符号之外发送多少修复符号：
+// 固定数目、按 K 的百分比，或按预计丢包率自动计算
+type RepairOverhead struct {
+	Percent float64 // 大于 0 时按 K 的百分比（向上取整）
+	Symbols uint32  // 固定的修复符号数
+
+	// Auto 为 true 时由 RepairForLoss 按丢包率 LossRate 和目标恢复概率 Success 计算
+	Auto     bool
+	LossRate float64
+	Success  float64
+}
+
+// ParseRepairOverhead 解析配置中的修复冗余："20%" 表示 K 的 20%，"32" 表示 32 个修复符号，
+// "auto" 表示按丢包率计算（LossRate 与 Success 由调用方填写），空串表示不发送修复符号
+func ParseRepairOverhead(s string) (RepairOverhead, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "":
+		return RepairOverhead{}, nil
+	case strings.EqualFold(s, "auto"):
+		return RepairOverhead{Auto: true}, nil
+	case strings.HasSuffix(s, "%"):
+		percent, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s, "%")), 64)
+		if err != nil || percent < 0 || math.IsInf(percent, 0) {
+			return RepairOverhead{}, fmt.Errorf("invalid repair overhead %q", s)
+		}
+		return RepairOverhead{Percent: percent}, nil
+	default:
+		symbols, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return RepairOverhead{}, fmt.Errorf("invalid repair overhead %q: %w", s, err)
+		}
+		return RepairOverhead{Symbols: uint32(symbols)}, nil
+	}
+}
+
+func (r RepairOverhead) String() string {
+	switch {
+	case r.Auto:
+		return fmt.Sprintf("auto(loss=%g%%, success=%g%%)", r.LossRate*100, r.Success*100)
+	case r.Percent > 0:
+		return fmt.Sprintf("%g%%", r.Percent)
+	default:
+		return strconv.FormatUint(uint64(r.Symbols), 10)
+	}
+}
+
+// RepairSymbols 返回 k 个源符号的源块应发送的修复符号数，不超过 maxRepair
+func (r RepairOverhead) RepairSymbols(scheme FECScheme, k, maxRepair uint32) uint32 {
+	switch {
+	case r.Auto:
+		repair, _ := RepairForLoss(scheme, k, maxRepair, r.LossRate, r.Success)
+		return repair
+	case r.Percent > 0:
+		return uint32(min(math.Ceil(float64(k)*r.Percent/100), float64(maxRepair)))
+	default:
+		return min(r.Symbols, maxRepair)
+	}
+}
+
+// RepairForLoss 计算在每个包独立以 loss 概率丢失时，使 k 个源符号的源块以不低于 success 的概率
+// 恢复所需的最少修复符号数（不超过 maxRepair），同时返回该修复符号数下的恢复概率。
+// 恢复条件为至少收到 k + ReceptionOverhead(k) 个编码符号
+func RepairForLoss(scheme FECScheme, k, maxRepair uint32, loss, success float64) (uint32, float64) {
+	need := k + scheme.ReceptionOverhead(k)
+	probability := func(repair uint32) float64 { return DecodeProbability(k+repair, need, loss) }
+
+	if p := probability(0); p >= success || maxRepair == 0 {
+		return 0, p
+	}
+	// 先倍增找到满足要求的上界，再二分
+	lo, hi := uint32(0), uint32(1)
+	for hi < maxRepair && probability(hi) < success {
+		lo = hi
+		hi = min(hi*2, maxRepair)
+	}
+	if p := probability(hi); p < success {
+		return hi, p
+	}
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		if probability(mid) >= success {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return hi, probability(hi)
+}
+
+// DecodeProbability 返回发送 n 个编码符号、每个符号独立以 loss 概率丢失时至少收到 need 个的概率
+func DecodeProbability(n, need uint32, loss float64) float64 {
+	switch {
+	case need == 0 || loss <= 0:
+		return 1
+	case need > n || loss >= 1:
+		return 0
+	}
+
+	// 二项分布尾部概率，在对数域计算各项以免溢出
+	logRecv, logLoss := math.Log1p(-loss), math.Log(loss)
+	lgN, _ := math.Lgamma(float64(n) + 1)
+	var p float64
+	for i := uint64(need); i <= uint64(n); i++ {
+		lgI, _ := math.Lgamma(float64(i) + 1)
+		lgRest, _ := math.Lgamma(float64(uint64(n)-i) + 1)
+		p += math.Exp(lgN - lgI - lgRest + float64(i)*logRecv + float64(uint64(n)-i)*logLoss)
+	}
+	return min(p, 1)
+}
@@ -0,0 +1,187 @@
+package fec
+
+import (
+	oti "FluteTest/pkg/oti"
+	"fmt"
+
+	raptorq "github.com/xssnick/raptorq"
+)
+
+// RaptorQ 的 ESI 为 24 位（RFC 6330 3.2）
+const raptorQMaxESI = 1<<24 - 1
+
+// raptorQScheme 为 RFC 6330 RaptorQ。源块拆分为 N 个子块分别编码，
+// 同一 ESI 的各子符号首尾相接构成一个编码符号。FEC Payload ID 为 SBN(8) | ESI(24)
+type raptorQScheme struct {
+	payloadID
+}
+
+func (raptorQScheme) EncodingID() uint8 { return oti.FECEncodingRaptorQ }
+func (raptorQScheme) Name() string      { return "RaptorQ" }
+
+// ReceptionOverhead 为 2：收到 K + 2 个符号时解码失败概率约为 10^-6（RFC 6330 1）
+func (raptorQScheme) ReceptionOverhead(uint32) uint32 { return 2 }
+
+func (raptorQScheme) NewEncoder(o oti.Oti, sb oti.SourceBlock, block []byte) (Encoder, error) {
+	subSymbolSizes, err := o.SubSymbolSizes()
+	if err != nil {
+		return nil, err
+	}
+
+	K := int(sb.Symbols)
+	enc := &raptorQEncoder{subEncoders: make([]*raptorq.Encoder, 0, len(subSymbolSizes)), K: sb.Symbols}
+	for _, size := range subSymbolSizes {
+		enc.symbolSize += int(size)
+	}
+
+	offset := 0
+	for _, size := range subSymbolSizes {
+		subBlock := block
+		if len(subSymbolSizes) > 1 {
+			// 子块 j 由每个源符号中 [offset, offset+size) 的字节组成
+			subBlock = make([]byte, K*int(size))
+			for i := 0; i < K; i++ {
+				start := i*enc.symbolSize + offset
+				if start >= len(block) {
+					break
+				}
+				end := min(start+int(size), len(block))
+				copy(subBlock[i*int(size):], block[start:end])
+			}
+		}
+
+		subEncoder, err := raptorq.NewRaptorQ(size).CreateEncoder(subBlock)
+		if err != nil {
+			return nil, fmt.Errorf("create RaptorQ encoder failed: %w", err)
+		}
+		enc.subEncoders = append(enc.subEncoders, subEncoder)
+		offset += int(size)
+	}
+
+	return enc, nil
+}
+
+func (raptorQScheme) NewDecoder(o oti.Oti, sb oti.SourceBlock) (Decoder, error) {
+	subSizes, err := o.SubSymbolSizes()
+	if err != nil {
+		return nil, err
+	}
+
+	d := &raptorQDecoder{
+		block:       sb,
+		subSizes:    subSizes,
+		subDecoders: make([]*raptorq.Decoder, 0, len(subSizes)),
+		subDone:     make([][]byte, len(subSizes)),
+	}
+	for _, size := range subSizes {
+		d.symbolSize += int(size)
+		// 子块按完整的 K 个子符号解码，末尾补零部分在拼接后截掉
+		subDecoder, err := raptorq.NewRaptorQ(size).CreateDecoder(sb.Symbols * size)
+		if err != nil {
+			return nil, fmt.Errorf("create RaptorQ decoder for block %d: %w", sb.SBN, err)
+		}
+		d.subDecoders = append(d.subDecoders, subDecoder)
+	}
+	return d, nil
+}
+
+type raptorQEncoder struct {
+	subEncoders []*raptorq.Encoder
+	symbolSize  int
+	K           uint32
+}
+
+func (e *raptorQEncoder) GenSymbol(esi uint32) ([]byte, error) {
+	if esi > raptorQMaxESI {
+		return nil, fmt.Errorf("ESI %d exceeds 24 bits", esi)
+	}
+	if len(e.subEncoders) == 1 {
+		return e.subEncoders[0].GenSymbol(esi), nil
+	}
+
+	symbol := make([]byte, 0, e.symbolSize)
+	for _, subEncoder := range e.subEncoders {
+		symbol = append(symbol, subEncoder.GenSymbol(esi)...)
+	}
+	return symbol, nil
+}
+
+func (e *raptorQEncoder) MaxRepairSymbols() uint32 { return raptorQMaxESI + 1 - e.K }
+
+// raptorQDecoder 对一个源块的各子块分别进行 RaptorQ 解码
+type raptorQDecoder struct {
+	block       oti.SourceBlock
+	subSizes    []uint32
+	symbolSize  int
+	subDecoders []*raptorq.Decoder
+	subDone     [][]byte // 已解码成功的子块数据
+	data        []byte
+}
+
+// AddSymbol 将编码符号拆分为各子块的子符号并尝试解码，所有子块解码成功后返回 true
+func (d *raptorQDecoder) AddSymbol(esi uint32, symbol []byte) (bool, error) {
+	if d.data != nil {
+		return true, nil
+	}
+	if len(symbol) != d.symbolSize {
+		return false, fmt.Errorf("incorrect symbol size %d, should be %d", len(symbol), d.symbolSize)
+	}
+
+	pending := 0
+	offset := 0
+	for j, subDecoder := range d.subDecoders {
+		size := int(d.subSizes[j])
+		subSymbol := symbol[offset : offset+size]
+		offset += size
+		if d.subDone[j] != nil {
+			continue
+		}
+
+		canDecode, err := subDecoder.AddSymbol(esi, subSymbol)
+		if err != nil {
+			return false, fmt.Errorf("add symbol %d: %w", esi, err)
+		}
+		if canDecode {
+			ok, data, err := subDecoder.Decode()
+			if err != nil {
+				return false, fmt.Errorf("decode: %w", err)
+			}
+			if ok {
+				d.subDone[j] = data
+				continue
+			}
+			// 符号线性相关，等待更多修复符号
+		}
+		pending++
+	}
+	if pending > 0 {
+		return false, nil
+	}
+
+	d.data = d.assemble()
+	d.subDecoders = nil
+	d.subDone = nil
+	return true, nil
+}
+
+func (d *raptorQDecoder) Data() []byte { return d.data }
+
+// assemble 将各子块按符号交织还原为源块，并截掉末尾补零
+func (d *raptorQDecoder) assemble() []byte {
+	if len(d.subDone) == 1 {
+		block := make([]byte, d.block.Length)
+		copy(block, d.subDone[0])
+		return block
+	}
+
+	full := make([]byte, int(d.block.Symbols)*d.symbolSize)
+	offset := 0
+	for j, sub := range d.subDone {
+		size := int(d.subSizes[j])
+		for i := 0; i < int(d.block.Symbols); i++ {
+			copy(full[i*d.symbolSize+offset:i*d.symbolSize+offset+size], sub[i*size:(i+1)*size])
+		}
+		offset += size
+	}
+	return full[:d.block.Length]
+}
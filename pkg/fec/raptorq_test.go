@@ -0,0 +1,76 @@
+package fec
+
+import (
+	oti "FluteTest/pkg/oti"
+	"bytes"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestRaptorQPayloadID(t *testing.T) {
+	scheme, _ := Lookup(oti.FECEncodingRaptorQ)
+	checkPayloadIDs(t, scheme, []payloadIDCase{
+		{sbn: 0, esi: 0, wire: []byte{0, 0, 0, 0}},
+		{sbn: 1, esi: 2, wire: []byte{1, 0, 0, 2}},
+		{sbn: 0xab, esi: 0x123456, wire: []byte{0xab, 0x12, 0x34, 0x56}},
+		{sbn: 0xff, esi: 1<<24 - 1, wire: []byte{0xff, 0xff, 0xff, 0xff}},
+		{sbn: 0x100, esi: 0, err: true},
+		{sbn: 0, esi: 1 << 24, err: true},
+	})
+}
+
+// TestRaptorQErasures 源符号为补零后的对象数据；丢失部分源符号时由 K + 2 个符号恢复源块，少于 K 个时不能恢复
+func TestRaptorQErasures(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		B, WS     uint32
+		length    uint64
+		subBlocks uint16
+	}{
+		{"single symbol block", 0, 0, 9, 1},
+		{"padded last symbol", 0, 0, 50*64 + 17, 1},
+		{"several blocks", 40, 0, 100*64 + 3, 1},
+		{"sub-blocks", 0, 512, 20 * 64, 3},
+		{"sub-blocks with padding", 30, 1024, 75*64 - 21, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			scheme, _ := Lookup(oti.FECEncodingRaptorQ)
+			o, blocks, data := testObject(t, oti.NewRaptorQ(64, tc.B, tc.WS), tc.length)
+			if o.SubBlocks != tc.subBlocks {
+				t.Fatalf("object partitioned into %d sub-blocks, want %d", o.SubBlocks, tc.subBlocks)
+			}
+			rng := rand.New(rand.NewPCG(6, tc.length))
+			for _, sb := range blocks {
+				symbols := encodeBlock(t, scheme, o, sb, data)
+				k := sb.Symbols
+				want := data[sb.Offset : sb.Offset+sb.Length]
+
+				// 系统码：源符号 i 为源块第 i 个符号，最后一个符号补零到 T 字节
+				padded := make([]byte, int(k)*int(o.EncodingSymbolLength))
+				copy(padded, want)
+				for esi := range k {
+					source := padded[esi*uint32(o.EncodingSymbolLength) : (esi+1)*uint32(o.EncodingSymbolLength)]
+					if !bytes.Equal(symbols[esi], source) {
+						t.Fatalf("block %d: source symbol %d is %x, want %x", sb.SBN, esi, symbols[esi], source)
+					}
+				}
+
+				// 丢失约一半源符号，以修复符号补足 K + 2 个
+				lost := (k + 1) / 2
+				received := shuffledESIs(rng, k)[lost:]
+				for esi := k; esi < k+lost+2; esi++ {
+					received = append(received, esi)
+				}
+				rng.Shuffle(len(received), func(i, j int) { received[i], received[j] = received[j], received[i] })
+				got, ok := decodeBlock(t, scheme, o, sb, symbols, received)
+				if !ok || !bytes.Equal(got, want) {
+					t.Fatalf("block %d (k=%d) not recovered with %d source symbols lost", sb.SBN, k, lost)
+				}
+
+				if _, ok := decodeBlock(t, scheme, o, sb, symbols, received[:k-1]); ok {
+					t.Fatalf("block %d (k=%d) recovered from %d symbols", sb.SBN, k, k-1)
+				}
+			}
+		})
+	}
+}
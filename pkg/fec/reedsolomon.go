@@ -0,0 +1,194 @@
+package fec
+
+import (
+	oti "FluteTest/pkg/oti"
+	"fmt"
+	"sort"
+)
+
+// reedSolomonScheme 为 RFC 5510 的 GF(2^8) Reed-Solomon 码（m = 8）。
+// 系统码，生成矩阵 G = V(k,k)^-1 * V(k,n)，V 为 Vandermonde 矩阵 V[i][j] = α^(i*j)，
+// 因此前 k 个编码符号即源符号。FEC Payload ID 为 SBN(24) | ESI(8)
+type reedSolomonScheme struct {
+	payloadID
+}
+
+func (reedSolomonScheme) EncodingID() uint8 { return oti.FECEncodingReedSolomon }
+func (reedSolomonScheme) Name() string      { return "ReedSolomon" }
+
+// ReceptionOverhead 为 0：Reed-Solomon 为 MDS 码，任意 k 个编码符号即可恢复
+func (reedSolomonScheme) ReceptionOverhead(uint32) uint32 { return 0 }
+
+// vandermondeInverse 返回 V(k,k) 的逆矩阵
+func vandermondeInverse(k int) (gfMatrix, error) {
+	v := newGFMatrix(k)
+	for i := 0; i < k; i++ {
+		for j := 0; j < k; j++ {
+			v[i][j] = gfPow(i * j)
+		}
+	}
+	return v.invert()
+}
+
+// generatorColumn 返回生成矩阵 G 的第 esi 列
+func generatorColumn(vkkInv gfMatrix, esi uint32) []byte {
+	k := len(vkkInv)
+	column := make([]byte, k)
+	for i := 0; i < k; i++ {
+		var c byte
+		for l := 0; l < k; l++ {
+			c ^= gfMul[vkkInv[i][l]][gfPow(l*int(esi))]
+		}
+		column[i] = c
+	}
+	return column
+}
+
+func (reedSolomonScheme) NewEncoder(o oti.Oti, sb oti.SourceBlock, block []byte) (Encoder, error) {
+	n, err := encodingSymbols(o, sb.Symbols, 255)
+	if err != nil {
+		return nil, err
+	}
+	vkkInv, err := vandermondeInverse(int(sb.Symbols))
+	if err != nil {
+		return nil, err
+	}
+
+	source := splitSymbols(block, int(sb.Symbols), int(o.EncodingSymbolLength))
+	return &reedSolomonEncoder{source: source, n: n, vkkInv: vkkInv}, nil
+}
+
+func (reedSolomonScheme) NewDecoder(o oti.Oti, sb oti.SourceBlock) (Decoder, error) {
+	n, err := encodingSymbols(o, sb.Symbols, 255)
+	if err != nil {
+		return nil, err
+	}
+	return &reedSolomonDecoder{
+		block:      sb,
+		n:          n,
+		symbolSize: int(o.EncodingSymbolLength),
+		symbols:    make(map[uint32][]byte),
+	}, nil
+}
+
+type reedSolomonEncoder struct {
+	source [][]byte
+	n      uint32
+	vkkInv gfMatrix
+}
+
+func (e *reedSolomonEncoder) GenSymbol(esi uint32) ([]byte, error) {
+	k := uint32(len(e.source))
+	if esi < k {
+		return e.source[esi], nil
+	}
+	if esi >= e.n {
+		return nil, fmt.Errorf("ESI %d out of range (n=%d)", esi, e.n)
+	}
+
+	column := generatorColumn(e.vkkInv, esi)
+	symbol := make([]byte, len(e.source[0]))
+	for i, src := range e.source {
+		gfMulAdd(symbol, src, column[i])
+	}
+	return symbol, nil
+}
+
+func (e *reedSolomonEncoder) MaxRepairSymbols() uint32 { return e.n - uint32(len(e.source)) }
+
+type reedSolomonDecoder struct {
+	block      oti.SourceBlock
+	n          uint32
+	symbolSize int
+	symbols    map[uint32][]byte // ESI -> 编码符号（已补齐到 E 字节）
+	data       []byte
+}
+
+// AddSymbol 收到任意 k 个不同的编码符号后即可恢复源块
+func (d *reedSolomonDecoder) AddSymbol(esi uint32, symbol []byte) (bool, error) {
+	if d.data != nil {
+		return true, nil
+	}
+	if esi >= d.n {
+		return false, fmt.Errorf("ESI %d out of range (n=%d)", esi, d.n)
+	}
+	if len(symbol) > d.symbolSize || (len(symbol) < d.symbolSize && esi != d.block.Symbols-1) {
+		return false, fmt.Errorf("incorrect symbol size %d, should be %d", len(symbol), d.symbolSize)
+	}
+	if _, dup := d.symbols[esi]; dup {
+		return false, nil
+	}
+
+	padded := make([]byte, d.symbolSize)
+	copy(padded, symbol)
+	d.symbols[esi] = padded
+	if uint32(len(d.symbols)) < d.block.Symbols {
+		return false, nil
+	}
+
+	source, err := d.decode()
+	if err != nil {
+		return false, err
+	}
+	d.data = make([]byte, 0, int(d.block.Symbols)*d.symbolSize)
+	for _, s := range source {
+		d.data = append(d.data, s...)
+	}
+	d.data = d.data[:d.block.Length]
+	d.symbols = nil
+	return true, nil
+}
+
+// decode 由 k 个编码符号求解源符号：recv = M * s，M[r][i] = G[i][esi_r]
+func (d *reedSolomonDecoder) decode() ([][]byte, error) {
+	k := int(d.block.Symbols)
+	source := make([][]byte, k)
+	missing := 0
+	for i := 0; i < k; i++ {
+		if s, ok := d.symbols[uint32(i)]; ok {
+			source[i] = s
+		} else {
+			missing++
+		}
+	}
+	if missing == 0 {
+		return source, nil
+	}
+
+	esis := make([]uint32, 0, len(d.symbols))
+	for esi := range d.symbols {
+		esis = append(esis, esi)
+	}
+	sort.Slice(esis, func(a, b int) bool { return esis[a] < esis[b] })
+	esis = esis[:k]
+
+	vkkInv, err := vandermondeInverse(k)
+	if err != nil {
+		return nil, err
+	}
+	m := newGFMatrix(k)
+	for r, esi := range esis {
+		column := generatorColumn(vkkInv, esi)
+		for i := 0; i < k; i++ {
+			m[r][i] = column[i]
+		}
+	}
+	mInv, err := m.invert()
+	if err != nil {
+		return nil, fmt.Errorf("decode block %d: %w", d.block.SBN, err)
+	}
+
+	for i := 0; i < k; i++ {
+		if source[i] != nil {
+			continue
+		}
+		s := make([]byte, d.symbolSize)
+		for r, esi := range esis {
+			gfMulAdd(s, d.symbols[esi], mInv[i][r])
+		}
+		source[i] = s
+	}
+	return source, nil
+}
+
+func (d *reedSolomonDecoder) Data() []byte { return d.data }
@@ -0,0 +1,65 @@
+package fec
+
+import (
+	oti "FluteTest/pkg/oti"
+	"bytes"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestReedSolomonPayloadID(t *testing.T) {
+	scheme, _ := Lookup(oti.FECEncodingReedSolomon)
+	checkPayloadIDs(t, scheme, []payloadIDCase{
+		{sbn: 0, esi: 0, wire: []byte{0, 0, 0, 0}},
+		{sbn: 1, esi: 254, wire: []byte{0, 0, 1, 0xfe}},
+		{sbn: 0x123456, esi: 0x78, wire: []byte{0x12, 0x34, 0x56, 0x78}},
+		{sbn: 1<<24 - 1, esi: 255, wire: []byte{0xff, 0xff, 0xff, 0xff}},
+		{sbn: 1 << 24, esi: 0, err: true},
+		{sbn: 0, esi: 256, err: true},
+	})
+}
+
+// TestReedSolomonErasures 丢失任意 n-k 个编码符号后仍能由剩下的 k 个恢复源块，少于 k 个时不能恢复
+func TestReedSolomonErasures(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		B, repair uint32
+		length    uint64
+	}{
+		{"single symbol block", 1, 2, 7},
+		{"small block", 10, 4, 10*32 - 3},
+		{"several blocks", 20, 5, 45*32 + 1},
+		{"max_n 255", 200, 55, 200 * 32},
+		{"no repair", 8, 0, 8 * 32},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			scheme, _ := Lookup(oti.FECEncodingReedSolomon)
+			o, blocks, data := testObject(t, oti.NewReedSolomon(32, tc.B, tc.repair), tc.length)
+			rng := rand.New(rand.NewPCG(2, tc.length))
+			for _, sb := range blocks {
+				symbols := encodeBlock(t, scheme, o, sb, data)
+				n, k := uint32(len(symbols)), sb.Symbols
+				want := data[sb.Offset : sb.Offset+sb.Length]
+
+				received := [][]uint32{shuffledESIs(rng, n)[:k]}
+				if n-k >= k {
+					// 全部源符号丢失，只由修复符号恢复
+					repairOnly := make([]uint32, 0, k)
+					for esi := n - k; esi < n; esi++ {
+						repairOnly = append(repairOnly, esi)
+					}
+					received = append(received, repairOnly)
+				}
+				for _, esis := range received {
+					got, ok := decodeBlock(t, scheme, o, sb, symbols, esis)
+					if !ok || !bytes.Equal(got, want) {
+						t.Fatalf("block %d (k=%d, n=%d) not recovered from ESIs %v", sb.SBN, k, n, esis)
+					}
+				}
+				if _, ok := decodeBlock(t, scheme, o, sb, symbols, received[0][:k-1]); ok {
+					t.Fatalf("block %d (k=%d) recovered from %d symbols", sb.SBN, k, k-1)
+				}
+			}
+		})
+	}
+}
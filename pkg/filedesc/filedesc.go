@@ -1,10 +1,19 @@
 package filedesc
 
+import fec "FluteTest/pkg/fec"
+
 type FileDesc struct {
-	FdtID       uint32
-	Path        string
-	Name        string
-	Size        int64
-	ContentType string
-	Md5         string
-}
\ No newline at end of file
+	FdtID           uint32 // 登记该文件的 FDT 实例号
+	TOI             uint64
+	Path            string
+	Name            string // 以 "/" 分隔的相对路径，作为 FDT 的 Content-Location
+	Size            int64  // 传输长度，即文件在磁盘上的字节数
+	ContentLength   int64  // 按 ContentEncoding 解码后的长度；ContentEncoding 为空时不需设置（等于 Size），为 0 表示未知
+	ContentType     string
+	ContentEncoding string
+	Md5             string // 十六进制
+	Sha256          string // 十六进制，为空时不在 FDT 中携带
+	Directory       bool   // 目录：只在 FDT 中登记（Content-Location 以 "/" 结尾），不发送数据
+
+	RepairOverhead *fec.RepairOverhead // 仅发送端使用，为 nil 时使用会话配置
+}
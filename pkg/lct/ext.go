@@ -0,0 +1,322 @@
+package lct
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 头部扩展类型 HET（RFC 5651 5.2，RFC 6726 3.4）
+// HET 0~127 为变长扩展（带 HEL），128~255 为固定 32 位扩展
+const (
+	ExtNOP  uint8 = 0
+	ExtAUTH uint8 = 1
+	ExtTIME uint8 = 2
+	ExtFTI  uint8 = 64
+	ExtFDT  uint8 = 192
+	ExtCENC uint8 = 193
+)
+
+// Extension 是一个 LCT 头部扩展
+type Extension interface {
+	// Type 返回扩展类型 HET
+	Type() uint8
+	// Content 返回扩展内容（不含 HET/HEL）。
+	// 固定长度扩展须为 3 字节；变长扩展按 4 字节对齐时自动补零
+	Content() ([]byte, error)
+}
+
+// ExtensionDecoder 将扩展内容解析为具体类型
+type ExtensionDecoder func(content []byte) (Extension, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[uint8]ExtensionDecoder{}
+)
+
+// RegisterExtension 注册 HET 对应的扩展解析函数，重复注册会覆盖之前的解析函数
+func RegisterExtension(het uint8, decode ExtensionDecoder) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[het] = decode
+}
+
+func init() {
+	RegisterExtension(ExtNOP, func(content []byte) (Extension, error) { return NOPExt{}, nil })
+	RegisterExtension(ExtAUTH, decodeAuthExt)
+	RegisterExtension(ExtTIME, decodeTimeExt)
+	RegisterExtension(ExtFTI, decodeFTIExt)
+	RegisterExtension(ExtFDT, decodeFDTExt)
+	RegisterExtension(ExtCENC, decodeCENCExt)
+}
+
+// EncodeExtensions 编码一组头部扩展，结果可直接作为 NewLCTHeader 的 extensions 参数
+func EncodeExtensions(exts []Extension) ([]byte, error) {
+	data := make([]byte, 0)
+	for _, ext := range exts {
+		het := ext.Type()
+		content, err := ext.Content()
+		if err != nil {
+			return nil, fmt.Errorf("encode extension %d: %w", het, err)
+		}
+
+		if het >= 128 {
+			if len(content) != 3 {
+				return nil, fmt.Errorf("fixed-length extension %d needs 3 bytes of content, got %d", het, len(content))
+			}
+			data = append(data, het)
+			data = append(data, content...)
+			continue
+		}
+
+		words := (2 + len(content) + 3) / 4
+		if words > 255 {
+			return nil, fmt.Errorf("extension %d too large: %d bytes", het, len(content))
+		}
+		data = append(data, het, uint8(words))
+		data = append(data, content...)
+		for pad := words*4 - 2 - len(content); pad > 0; pad-- {
+			data = append(data, 0)
+		}
+	}
+	return data, nil
+}
+
+// ParseExtensions 解析头部扩展。未注册的扩展以 RawExt 返回，
+// 已注册但内容非法的扩展返回错误
+func ParseExtensions(data []byte) ([]Extension, error) {
+	exts := make([]Extension, 0)
+	for offset := 0; offset < len(data); {
+		het := data[offset]
+		var content []byte
+		if het >= 128 {
+			if offset+4 > len(data) {
+				return nil, fmt.Errorf("extension %d truncated", het)
+			}
+			content = data[offset+1 : offset+4]
+			offset += 4
+		} else {
+			if offset+2 > len(data) {
+				return nil, fmt.Errorf("extension %d truncated", het)
+			}
+			hel := int(data[offset+1])
+			if hel == 0 {
+				return nil, fmt.Errorf("extension %d has zero HEL", het)
+			}
+			end := offset + hel*4
+			if end > len(data) {
+				return nil, fmt.Errorf("extension %d truncated: HEL %d, %d bytes left", het, hel, len(data)-offset)
+			}
+			content = data[offset+2 : end]
+			offset = end
+		}
+
+		registryMu.RLock()
+		decode, ok := registry[het]
+		registryMu.RUnlock()
+		if !ok {
+			exts = append(exts, RawExt{HET: het, Data: content})
+			continue
+		}
+		ext, err := decode(content)
+		if err != nil {
+			return nil, fmt.Errorf("decode extension %d: %w", het, err)
+		}
+		exts = append(exts, ext)
+	}
+	return exts, nil
+}
+
+// RawExt 是未注册类型的扩展，内容原样保留
+type RawExt struct {
+	HET  uint8
+	Data []byte
+}
+
+func (e RawExt) Type() uint8              { return e.HET }
+func (e RawExt) Content() ([]byte, error) { return e.Data, nil }
+
+// NOPExt 为空扩展（EXT_NOP），接收端直接忽略
+type NOPExt struct{}
+
+func (NOPExt) Type() uint8              { return ExtNOP }
+func (NOPExt) Content() ([]byte, error) { return []byte{0, 0}, nil }
+
+// AuthExt 为认证扩展（EXT_AUTH），内容格式由具体认证方案定义
+type AuthExt struct {
+	Data []byte
+}
+
+func (AuthExt) Type() uint8                { return ExtAUTH }
+func (e AuthExt) Content() ([]byte, error) { return e.Data, nil }
+
+func decodeAuthExt(content []byte) (Extension, error) {
+	data := make([]byte, len(content))
+	copy(data, content)
+	return AuthExt{Data: data}, nil
+}
+
+// EXT_TIME Use 字段各标志位
+const (
+	timeUseSCTHigh = 1 << 15
+	timeUseSCTLow  = 1 << 14
+	timeUseERT     = 1 << 13
+	timeUseSLC     = 1 << 12
+)
+
+// NTPEpochOffset 为 NTP 时间戳起点（1900-01-01）与 Unix 时间起点的秒差，EXT_TIME 与 FDT 的 Expires 共用
+const NTPEpochOffset = 2208988800
+
+// TimeExt 为时间扩展（EXT_TIME，RFC 5651 5.2.2）
+type TimeExt struct {
+	SenderCurrentTime time.Time     // SCT，零值表示不携带
+	ExpectedResidual  time.Duration // ERT，0 表示不携带
+	SessionLastChange time.Time     // SLC，零值表示不携带
+}
+
+func (TimeExt) Type() uint8 { return ExtTIME }
+
+func (e TimeExt) Content() ([]byte, error) {
+	var use uint16
+	data := make([]byte, 2)
+	if !e.SenderCurrentTime.IsZero() {
+		use |= timeUseSCTHigh | timeUseSCTLow
+		sec, frac := toNTP(e.SenderCurrentTime)
+		data = binary.BigEndian.AppendUint32(data, sec)
+		data = binary.BigEndian.AppendUint32(data, frac)
+	}
+	if e.ExpectedResidual > 0 {
+		use |= timeUseERT
+		data = binary.BigEndian.AppendUint32(data, uint32(e.ExpectedResidual/time.Millisecond))
+	}
+	if !e.SessionLastChange.IsZero() {
+		use |= timeUseSLC
+		sec, _ := toNTP(e.SessionLastChange)
+		data = binary.BigEndian.AppendUint32(data, sec)
+	}
+	binary.BigEndian.PutUint16(data[0:2], use)
+	return data, nil
+}
+
+func decodeTimeExt(content []byte) (Extension, error) {
+	if len(content) < 2 {
+		return nil, fmt.Errorf("EXT_TIME too short: %d", len(content))
+	}
+	use := binary.BigEndian.Uint16(content[0:2])
+	offset := 2
+	next := func() (uint32, error) {
+		if offset+4 > len(content) {
+			return 0, fmt.Errorf("EXT_TIME truncated")
+		}
+		v := binary.BigEndian.Uint32(content[offset : offset+4])
+		offset += 4
+		return v, nil
+	}
+
+	var e TimeExt
+	var sec, frac uint32
+	var err error
+	if use&timeUseSCTHigh != 0 {
+		if sec, err = next(); err != nil {
+			return nil, err
+		}
+	}
+	if use&timeUseSCTLow != 0 {
+		if frac, err = next(); err != nil {
+			return nil, err
+		}
+	}
+	if use&(timeUseSCTHigh|timeUseSCTLow) != 0 {
+		e.SenderCurrentTime = fromNTP(sec, frac)
+	}
+	if use&timeUseERT != 0 {
+		ert, err := next()
+		if err != nil {
+			return nil, err
+		}
+		e.ExpectedResidual = time.Duration(ert) * time.Millisecond
+	}
+	if use&timeUseSLC != 0 {
+		slc, err := next()
+		if err != nil {
+			return nil, err
+		}
+		e.SessionLastChange = fromNTP(slc, 0)
+	}
+	return e, nil
+}
+
+func toNTP(t time.Time) (uint32, uint32) {
+	sec := uint32(t.Unix() + NTPEpochOffset)
+	frac := uint32((uint64(t.Nanosecond()) << 32) / uint64(time.Second))
+	return sec, frac
+}
+
+func fromNTP(sec, frac uint32) time.Time {
+	nsec := (uint64(frac) * uint64(time.Second)) >> 32
+	return time.Unix(int64(sec)-NTPEpochOffset, int64(nsec))
+}
+
+// FTIExt 为 FEC 对象传输信息扩展（EXT_FTI，RFC 5052），
+// 内容格式由 FEC Encoding ID 决定，由上层按 Codepoint 解析
+type FTIExt struct {
+	Data []byte
+}
+
+func (FTIExt) Type() uint8                { return ExtFTI }
+func (e FTIExt) Content() ([]byte, error) { return e.Data, nil }
+
+func decodeFTIExt(content []byte) (Extension, error) {
+	data := make([]byte, len(content))
+	copy(data, content)
+	return FTIExt{Data: data}, nil
+}
+
+// FDT 扩展版本号（RFC 6726 为 2）
+const FDTVersion = 2
+
+// FDTExt 为 FDT 实例扩展（EXT_FDT，RFC 6726 3.4.1），仅出现在 TOI 0 的数据包中
+type FDTExt struct {
+	Version    uint8  // 4 位
+	InstanceID uint32 // 20 位
+}
+
+func (FDTExt) Type() uint8 { return ExtFDT }
+
+func (e FDTExt) Content() ([]byte, error) {
+	if e.InstanceID >= 1<<20 {
+		return nil, fmt.Errorf("FDT instance ID %d exceeds 20 bits", e.InstanceID)
+	}
+	version := e.Version
+	if version == 0 {
+		version = FDTVersion
+	}
+	v := uint32(version&0x0F)<<20 | e.InstanceID
+	return []byte{byte(v >> 16), byte(v >> 8), byte(v)}, nil
+}
+
+func decodeFDTExt(content []byte) (Extension, error) {
+	v := uint32(content[0])<<16 | uint32(content[1])<<8 | uint32(content[2])
+	return FDTExt{Version: uint8(v >> 20), InstanceID: v & 0xFFFFF}, nil
+}
+
+// 内容编码算法（RFC 6726 3.4.2）
+const (
+	CENCNull    uint8 = 0
+	CENCZlib    uint8 = 1
+	CENCDeflate uint8 = 2
+	CENCGzip    uint8 = 3
+)
+
+// CENCExt 为 FDT 实例内容编码扩展（EXT_CENC，RFC 6726 3.4.2）
+type CENCExt struct {
+	Encoding uint8
+}
+
+func (CENCExt) Type() uint8                { return ExtCENC }
+func (e CENCExt) Content() ([]byte, error) { return []byte{e.Encoding, 0, 0}, nil }
+
+func decodeCENCExt(content []byte) (Extension, error) {
+	return CENCExt{Encoding: content[0]}, nil
+}
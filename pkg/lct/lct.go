@@ -1,42 +1,209 @@
 package lct
 
-import "encoding/binary"
+import "fmt"
+
+// RFC 5651 LCT 头部格式
+//
+//	 0                   1                   2                   3
+//	 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//	|   V   | C |PSI|S| O |H|Res|A|B|   HDR_LEN     | Codepoint (CP)|
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//	| Congestion Control Information (CCI, length = 32*(C+1) bits)  |
+//	|  Transport Session Identifier (TSI, length = 32*S+16*H bits)  |
+//	|   Transport Object Identifier (TOI, length = 32*O+16*H bits)  |
+//	|                Header Extensions (if applicable)              |
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+const (
+	Version = 1
+
+	fixedHeaderLen = 4
+	maxHeaderLen   = 255 * 4 // HDR_LEN 为 8 位，单位 32 位字
+)
 
 type LCTHeader struct {
-	Version uint8 
-	Flags uint8
-	CCI uint8 
-	TSI uint32
-	TOI uint32
-	CloseObject bool 
-	CloseSession bool
-	CodePoint uint8
+	Version      uint8
+	PSI          uint8  // Protocol-Specific Indication，2 位
+	CCI          uint64 // 拥塞控制信息，编码时按需使用 32 或 64 位
+	TSI          uint64 // 传输会话标识，最长 48 位
+	TOI          uint64 // 传输对象标识，编码时最长 64 位
+	CloseObject  bool   // B 位
+	CloseSession bool   // A 位
+	CodePoint    uint8
+	HeaderLen    int // 解码得到的头部总长度（字节，包含扩展头）
+}
+
+// fieldLayout 计算 TSI/TOI 的最短编码方式，返回 S、O、H 三个标志位
+func fieldLayout(tsi, toi uint64) (s, o, h uint8, err error) {
+	if tsi >= 1<<48 {
+		return 0, 0, 0, fmt.Errorf("TSI %d exceeds 48 bits", tsi)
+	}
+
+	best := -1
+	for _, hh := range []uint8{0, 1} {
+		// TSI 长度 32*S+16*H，不能为 0
+		var ss uint8
+		found := false
+		for ss = 0; ss <= 1; ss++ {
+			bits := 32*uint(ss) + 16*uint(hh)
+			if bits > 0 && (bits >= 64 || tsi < 1<<bits) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			continue
+		}
+		// TOI 长度 32*O+16*H，不能为 0
+		var oo uint8
+		found = false
+		for oo = 0; oo <= 3; oo++ {
+			bits := 32*uint(oo) + 16*uint(hh)
+			if bits > 0 && (bits >= 64 || toi < 1<<bits) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			continue
+		}
+		size := int(32*ss+16*hh) + int(32*oo+16*hh)
+		if best < 0 || size < best {
+			best = size
+			s, o, h = ss, oo, hh
+		}
+	}
+	if best < 0 {
+		return 0, 0, 0, fmt.Errorf("cannot encode TSI %d / TOI %d", tsi, toi)
+	}
+	return s, o, h, nil
+}
+
+// putUint 以大端序将 v 写入 buf（长度 0~14 字节，超过 8 字节的高位补零）
+func putUint(buf []byte, v uint64) {
+	for i := len(buf) - 1; i >= 0; i-- {
+		buf[i] = byte(v)
+		v >>= 8
+	}
+}
+
+// readUint 以大端序读取 buf，超过 64 位的高位必须为 0
+func readUint(buf []byte) (uint64, error) {
+	var v uint64
+	for i, b := range buf {
+		if len(buf)-i > 8 && b != 0 {
+			return 0, fmt.Errorf("field of %d bytes exceeds 64 bits", len(buf))
+		}
+		v = v<<8 | uint64(b)
+	}
+	return v, nil
 }
 
+// NewLCTHeader 按 RFC 5651 编码 LCT 头部，extensions 为已编码的头部扩展（长度须为 4 的倍数）
+func NewLCTHeader(h LCTHeader, extensions []byte) ([]byte, error) {
+	if len(extensions)%4 != 0 {
+		return nil, fmt.Errorf("header extensions length %d is not a multiple of 4", len(extensions))
+	}
+
+	s, o, hw, err := fieldLayout(h.TSI, h.TOI)
+	if err != nil {
+		return nil, err
+	}
 
-func NewLCTHeader(h LCTHeader) []byte {
-	data := make([]byte, 0, 12) // 预分配足够空间
+	var c uint8
+	if h.CCI >= 1<<32 {
+		c = 1
+	}
 
-	// 组合 Version 和 Flags
-	versionAndFlags := (h.Version << 4) | (h.Flags & 0x0F)
-	data = append(data, versionAndFlags) // 1  byte
+	cciLen := 4 * int(c+1)
+	tsiLen := 4*int(s) + 2*int(hw)
+	toiLen := 4*int(o) + 2*int(hw)
+	headerLen := fixedHeaderLen + cciLen + tsiLen + toiLen + len(extensions)
+	if headerLen > maxHeaderLen {
+		return nil, fmt.Errorf("LCT header too large: %d bytes", headerLen)
+	}
 
-	// 写入 CCI（假设 CCI 是 uint8）
-	data = append(data, h.CCI) // 1 byte
+	version := h.Version
+	if version == 0 {
+		version = Version
+	}
 
-	// 写入 TSI（大端序，4字节）
-	tsiBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(tsiBytes, h.TSI) // 4 byte
-	data = append(data, tsiBytes...)
+	data := make([]byte, headerLen)
+	data[0] = version<<4 | c<<2 | h.PSI&0x03
+	data[1] = s<<7 | o<<5 | hw<<4
+	if h.CloseSession {
+		data[1] |= 0x02
+	}
+	if h.CloseObject {
+		data[1] |= 0x01
+	}
+	data[2] = uint8(headerLen / 4)
+	data[3] = h.CodePoint
 
-	// 写入 TOI（大端序，4字节）
-	toiBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(toiBytes, h.TOI) // 4 byte
-	data = append(data, toiBytes...)
+	offset := fixedHeaderLen
+	putUint(data[offset:offset+cciLen], h.CCI)
+	offset += cciLen
+	putUint(data[offset:offset+tsiLen], h.TSI)
+	offset += tsiLen
+	putUint(data[offset:offset+toiLen], h.TOI)
+	offset += toiLen
+	copy(data[offset:], extensions)
 
-	// CloseObject 和 CloseSession 无需写入
-	
-	return data
+	return data, nil
 }
 
+// ParseLCTHeader 解析 LCT 头部，返回头部字段以及未解析的头部扩展字节
+func ParseLCTHeader(data []byte) (LCTHeader, []byte, error) {
+	var h LCTHeader
+	if len(data) < fixedHeaderLen {
+		return h, nil, fmt.Errorf("LCT header too short: %d", len(data))
+	}
+
+	h.Version = data[0] >> 4
+	if h.Version != Version {
+		return h, nil, fmt.Errorf("unsupported LCT version %d", h.Version)
+	}
+	c := (data[0] >> 2) & 0x03
+	h.PSI = data[0] & 0x03
+	s := data[1] >> 7
+	o := (data[1] >> 5) & 0x03
+	hw := (data[1] >> 4) & 0x01
+	h.CloseSession = data[1]&0x02 != 0
+	h.CloseObject = data[1]&0x01 != 0
+	h.HeaderLen = int(data[2]) * 4
+	h.CodePoint = data[3]
+
+	cciLen := 4 * int(c+1)
+	tsiLen := 4*int(s) + 2*int(hw)
+	toiLen := 4*int(o) + 2*int(hw)
+	fieldsLen := fixedHeaderLen + cciLen + tsiLen + toiLen
+	if h.HeaderLen < fieldsLen {
+		return h, nil, fmt.Errorf("invalid HDR_LEN %d, fields need %d bytes", h.HeaderLen, fieldsLen)
+	}
+	if len(data) < h.HeaderLen {
+		return h, nil, fmt.Errorf("LCT header truncated: HDR_LEN %d, got %d", h.HeaderLen, len(data))
+	}
 
+	var err error
+	offset := fixedHeaderLen
+	if h.CCI, err = readUint(data[offset : offset+cciLen]); err != nil {
+		return h, nil, fmt.Errorf("CCI: %w", err)
+	}
+	offset += cciLen
+	if h.TSI, err = readUint(data[offset : offset+tsiLen]); err != nil {
+		return h, nil, fmt.Errorf("TSI: %w", err)
+	}
+	offset += tsiLen
+	if h.TOI, err = readUint(data[offset : offset+toiLen]); err != nil {
+		return h, nil, fmt.Errorf("TOI: %w", err)
+	}
+	offset += toiLen
+
+	return h, data[offset:h.HeaderLen], nil
+}
+
+// String 返回便于日志输出的头部摘要
+func (h LCTHeader) String() string {
+	return fmt.Sprintf("V(%d) CCI(%d) TSI(%d) TOI(%d) CP(%d) A(%v) B(%v) HDR_LEN(%d)",
+		h.Version, h.CCI, h.TSI, h.TOI, h.CodePoint, h.CloseSession, h.CloseObject, h.HeaderLen/4)
+}
@@ -0,0 +1,106 @@
+package lct
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLCTHeaderWireFormat(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		h    LCTHeader
+		ext  []byte
+		wire []byte
+	}{
+		{
+			name: "16-bit TSI and TOI",
+			h:    LCTHeader{TSI: 1, TOI: 2, CodePoint: 6},
+			wire: []byte{0x10, 0x10, 3, 6, 0, 0, 0, 0, 0, 1, 0, 2},
+		},
+		{
+			name: "close flags and PSI",
+			h:    LCTHeader{PSI: 2, TSI: 0x1234, TOI: 0, CloseSession: true, CloseObject: true},
+			wire: []byte{0x12, 0x13, 3, 0, 0, 0, 0, 0, 0x12, 0x34, 0, 0},
+		},
+		{
+			name: "32-bit TSI and TOI",
+			h:    LCTHeader{TSI: 0x12345678, TOI: 0x9abcdef0, CodePoint: 5},
+			wire: []byte{0x10, 0xa0, 4, 5, 0, 0, 0, 0, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0},
+		},
+		{
+			name: "64-bit CCI with extension",
+			h:    LCTHeader{CCI: 1 << 32, TSI: 1, TOI: 1, CodePoint: 3},
+			ext:  []byte{0, 1, 0, 0},
+			wire: []byte{0x14, 0x10, 5, 3, 0, 0, 0, 1, 0, 0, 0, 0, 0, 1, 0, 1, 0, 1, 0, 0},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := NewLCTHeader(tc.h, tc.ext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, tc.wire) {
+				t.Fatalf("encoded as %x, want %x", data, tc.wire)
+			}
+		})
+	}
+}
+
+// TestLCTHeaderRoundTrip 按最短字段编码的头部能解析回原值，扩展头原样返回
+func TestLCTHeaderRoundTrip(t *testing.T) {
+	ext := []byte{64, 1, 0x12, 0x34}
+	for _, h := range []LCTHeader{
+		{TSI: 0, TOI: 0},
+		{TSI: 1<<16 - 1, TOI: 1<<16 - 1},
+		{TSI: 1 << 16, TOI: 1},
+		{TSI: 1<<32 - 1, TOI: 1 << 32},
+		{TSI: 1<<48 - 1, TOI: 1<<48 - 1},
+		{TSI: 1 << 40, TOI: 1<<64 - 1, CCI: 1<<64 - 1, PSI: 3, CodePoint: 255, CloseObject: true},
+		{TSI: 7, TOI: 1 << 48, CloseSession: true},
+	} {
+		for _, extensions := range [][]byte{nil, ext} {
+			data, err := NewLCTHeader(h, extensions)
+			if err != nil {
+				t.Fatalf("%v: %v", h, err)
+			}
+			got, gotExt, err := ParseLCTHeader(append(data, 0xaa, 0xbb)) // 头部之后为载荷
+			if err != nil {
+				t.Fatalf("%v: parse %x: %v", h, data, err)
+			}
+			want := h
+			want.Version = Version
+			want.HeaderLen = len(data)
+			if got != want || !bytes.Equal(gotExt, extensions) {
+				t.Fatalf("%x parsed as %v ext %x, want %v ext %x", data, got, gotExt, want, extensions)
+			}
+		}
+	}
+}
+
+func TestLCTHeaderErrors(t *testing.T) {
+	if _, err := NewLCTHeader(LCTHeader{TSI: 1 << 48}, nil); err == nil {
+		t.Error("TSI of 49 bits encoded without error")
+	}
+	if _, err := NewLCTHeader(LCTHeader{TSI: 1}, []byte{1, 2, 3}); err == nil {
+		t.Error("extensions of 3 bytes encoded without error")
+	}
+
+	valid, err := NewLCTHeader(LCTHeader{TSI: 1, TOI: 2}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	badVersion := append([]byte(nil), valid...)
+	badVersion[0] = 2<<4 | badVersion[0]&0x0f
+	shortHdrLen := append([]byte(nil), valid...)
+	shortHdrLen[2] = 2
+	for name, data := range map[string][]byte{
+		"too short":       valid[:3],
+		"truncated":       valid[:len(valid)-1],
+		"version 2":       badVersion,
+		"HDR_LEN too low": shortHdrLen,
+	} {
+		if _, _, err := ParseLCTHeader(data); err == nil {
+			t.Errorf("%s: %x parsed without error", name, data)
+		}
+	}
+}
@@ -1,27 +1,229 @@
 package oti
 
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// FEC Encoding ID（IANA "FEC Encoding IDs" 注册表）
+const (
+	FECEncodingNoCode        uint8 = 0 // Compact No-Code，RFC 5445
+	FECEncodingLDPCStaircase uint8 = 3 // LDPC-Staircase，RFC 5170
+	FECEncodingReedSolomon   uint8 = 5 // Reed-Solomon over GF(2^8)，RFC 5510
+	FECEncodingRaptorQ       uint8 = 6 // RaptorQ，RFC 6330
+)
+
+// RFC 5510 中 m = 8 时每个源块最多 2^8 - 1 个编码符号，本实现固定 m = 8、G = 1
+const (
+	ReedSolomonM             = 8 // GF(2^m) 的 m
+	ReedSolomonSymbolsPerPkt = 1 // 每个数据包的编码符号数 G
+	reedSolomonMaxEncSymbols = 1<<ReedSolomonM - 1
+)
+
+// RFC 5170 中 ESI 为 20 位，N1 取 3..10（编码为 N1 - 3），PRNG 种子取 1..2^31-2，本实现固定 G = 1
+const (
+	LDPCMaxEncSymbols = 1 << 20 // 每个源块最多的编码符号数
+	LDPCSymbolsPerPkt = 1       // 每个数据包的编码符号数 G
+	LDPCMinN1         = 3       // N1 的最小值，OTI 中 N1 编码为 N1 - LDPCMinN1
+	ldpcDefaultN1     = LDPCMinN1
+	ldpcMaxN1         = 10
+	ldpcDefaultSeed   = 1
+	ldpcMaxSeed       = 1<<31 - 2
+)
+
+// 默认符号对齐字节数（RFC 6330 推荐 Al = 4）
+const defaultSymbolAlignment = 4
+
 type Oti struct {
-	FECEncodingID uint8
-	FECInstanceID uint16 
-	MaximumSourceBlockLength uint16 
-	EncodingSymbolLength uint16 
+	FECEncodingID            uint8
+	FECInstanceID            uint16
+	MaximumSourceBlockLength uint32 // 单个源块最大源符号数
+	EncodingSymbolLength     uint16 // 编码符号长度 T（字节）
+	MaxEncodingSymbols       uint32 // 每个源块最多编码符号数 max_n（Reed-Solomon 与 LDPC-Staircase）
+
+	// 仅 LDPC-Staircase：生成 H1 矩阵的 PRNG 种子与每列 "1" 的个数 N1
+	PRNGSeed uint32
+	N1       uint8
+
+	// 对象相关参数，由 WithTransferLength 根据文件大小计算
+	TransferLength  uint64 // 对象长度 F（字节）
+	SourceBlocks    uint16 // 源块数 Z
+	SubBlocks       uint16 // 子块数 N
+	SymbolAlignment uint8  // 符号对齐 Al
+
+	// 仅发送端使用：单个子块允许占用的最大内存 WS（字节），0 表示不拆分子块
+	MaxSubBlockSize uint32
 }
 
+// NewNoCode 创建 Compact No-Code 的 OTI，maxSourceBlockLength 为 0 时使用 ESI 允许的最大值
+func NewNoCode(encodingSymbolLength uint16, maxSourceBlockLength uint32) Oti {
+	if maxSourceBlockLength == 0 || maxSourceBlockLength > noCodeMaxSourceSymbols {
+		maxSourceBlockLength = noCodeMaxSourceSymbols
+	}
+	return Oti{
+		FECEncodingID:            FECEncodingNoCode,
+		FECInstanceID:            0,
+		EncodingSymbolLength:     encodingSymbolLength,
+		MaximumSourceBlockLength: maxSourceBlockLength,
+	}
+}
 
-func NewNoCode(encodingSymbolLength uint16) Oti {
+func NewRaptorQ(encodingSymbolLength uint16, maxSourceBlockLength uint32, maxSubBlockSize uint32) Oti {
 	return Oti{
-		FECEncodingID: 0,
-		FECInstanceID: 0,
-		EncodingSymbolLength:    encodingSymbolLength,
-		MaximumSourceBlockLength: 0,
+		FECEncodingID:            FECEncodingRaptorQ,
+		FECInstanceID:            0, // Fully-Specified 方案（ID < 128）没有 Instance ID
+		EncodingSymbolLength:     encodingSymbolLength,
+		MaximumSourceBlockLength: maxSourceBlockLength,
+		MaxSubBlockSize:          maxSubBlockSize,
 	}
 }
 
-func NewRaptorQ(encodingSymbolLength uint16) Oti {
+// NewReedSolomon 创建 Reed-Solomon 的 OTI。max_n 不超过 255，
+// 源块长度 B 取 maxSourceBlockLength 与 max_n - repairSymbols 中较小者，使完整源块带 repairSymbols 个修复符号
+func NewReedSolomon(encodingSymbolLength uint16, maxSourceBlockLength uint32, repairSymbols uint32) Oti {
+	repair := min(repairSymbols, reedSolomonMaxEncSymbols-1)
+	B := uint32(reedSolomonMaxEncSymbols) - repair
+	if maxSourceBlockLength > 0 && maxSourceBlockLength < B {
+		B = maxSourceBlockLength
+	}
 	return Oti{
-		FECEncodingID: 1,
-		FECInstanceID: 1,
-		EncodingSymbolLength:    encodingSymbolLength,
-		MaximumSourceBlockLength: 0,
-	}	
-}
\ No newline at end of file
+		FECEncodingID:            FECEncodingReedSolomon,
+		FECInstanceID:            0,
+		EncodingSymbolLength:     encodingSymbolLength,
+		MaximumSourceBlockLength: B,
+		MaxEncodingSymbols:       B + repair,
+	}
+}
+
+// NewLDPCStaircase 创建 LDPC-Staircase 的 OTI，完整源块带 repairSymbols 个修复符号（max_n = B + repairSymbols），
+// n1 或 seed 超出取值范围时使用默认值 N1 = 3、seed = 1
+func NewLDPCStaircase(encodingSymbolLength uint16, maxSourceBlockLength uint32, repairSymbols uint32, n1 uint8, seed uint32) Oti {
+	repair := min(repairSymbols, LDPCMaxEncSymbols-1)
+	B := maxSourceBlockLength
+	if B == 0 || B > LDPCMaxEncSymbols-repair {
+		B = LDPCMaxEncSymbols - repair
+	}
+	if n1 < LDPCMinN1 || n1 > ldpcMaxN1 {
+		n1 = ldpcDefaultN1
+	}
+	if seed == 0 || seed > ldpcMaxSeed {
+		seed = ldpcDefaultSeed
+	}
+	return Oti{
+		FECEncodingID:            FECEncodingLDPCStaircase,
+		FECInstanceID:            0,
+		EncodingSymbolLength:     encodingSymbolLength,
+		MaximumSourceBlockLength: B,
+		MaxEncodingSymbols:       B + repair,
+		PRNGSeed:                 seed,
+		N1:                       n1,
+	}
+}
+
+// WithRepairSymbols 为以 max_n 限定码率的方案（Reed-Solomon、LDPC-Staircase）重新设置 max_n，
+// 使完整源块可带 repairSymbols 个修复符号，必要时缩小 B；其余方案原样返回
+func (o Oti) WithRepairSymbols(repairSymbols uint32) Oti {
+	var r Oti
+	switch o.FECEncodingID {
+	case FECEncodingReedSolomon:
+		r = NewReedSolomon(o.EncodingSymbolLength, o.MaximumSourceBlockLength, repairSymbols)
+	case FECEncodingLDPCStaircase:
+		r = NewLDPCStaircase(o.EncodingSymbolLength, o.MaximumSourceBlockLength, repairSymbols, o.N1, o.PRNGSeed)
+	default:
+		return o
+	}
+	o.MaximumSourceBlockLength = r.MaximumSourceBlockLength
+	o.MaxEncodingSymbols = r.MaxEncodingSymbols
+	return o
+}
+
+// MarshalFTI 按 FEC 方案编码 OTI，格式与 EXT_FTI 扩展头中 HET/HEL 之后的内容一致
+func (o Oti) MarshalFTI() []byte {
+	switch o.FECEncodingID {
+	case FECEncodingRaptorQ:
+		// RFC 6330 3.3.2 / 3.3.3: F(40) | Reserved(8) | T(16) | Z(8) | N(16) | Al(8)
+		data := make([]byte, 12)
+		binary.BigEndian.PutUint64(data[0:8], o.TransferLength<<24|uint64(o.EncodingSymbolLength))
+		data[8] = uint8(o.SourceBlocks)
+		binary.BigEndian.PutUint16(data[9:11], o.SubBlocks)
+		data[11] = o.SymbolAlignment
+		return data
+	case FECEncodingReedSolomon:
+		// RFC 5510 5.2.3: F(48) | Reserved(16) | E(16) | m(8) | G(8) | B(16) | max_n(16)
+		data := make([]byte, 16)
+		binary.BigEndian.PutUint64(data[0:8], o.TransferLength<<16)
+		binary.BigEndian.PutUint16(data[8:10], o.EncodingSymbolLength)
+		data[10] = ReedSolomonM
+		data[11] = ReedSolomonSymbolsPerPkt
+		binary.BigEndian.PutUint16(data[12:14], uint16(o.MaximumSourceBlockLength))
+		binary.BigEndian.PutUint16(data[14:16], uint16(o.MaxEncodingSymbols))
+		return data
+	case FECEncodingLDPCStaircase:
+		// RFC 5170: F(48) | Reserved(16) | E(16) | N1m3(8) | G(8) | B(32) | max_n(32) | PRNG seed(32)
+		data := make([]byte, 24)
+		binary.BigEndian.PutUint64(data[0:8], o.TransferLength<<16)
+		binary.BigEndian.PutUint16(data[8:10], o.EncodingSymbolLength)
+		data[10] = o.N1 - LDPCMinN1
+		data[11] = LDPCSymbolsPerPkt
+		binary.BigEndian.PutUint32(data[12:16], o.MaximumSourceBlockLength)
+		binary.BigEndian.PutUint32(data[16:20], o.MaxEncodingSymbols)
+		binary.BigEndian.PutUint32(data[20:24], o.PRNGSeed)
+		return data
+	default:
+		// RFC 5445 3.2.3: F(48) | Reserved(16) | E(16) | B(32)
+		data := make([]byte, 14)
+		binary.BigEndian.PutUint64(data[0:8], o.TransferLength<<16)
+		binary.BigEndian.PutUint16(data[8:10], o.EncodingSymbolLength)
+		binary.BigEndian.PutUint32(data[10:14], o.MaximumSourceBlockLength)
+		return data
+	}
+}
+
+// UnmarshalFTI 解析 MarshalFTI 生成的 OTI
+func UnmarshalFTI(fecEncodingID uint8, fecInstanceID uint16, data []byte) (Oti, error) {
+	o := Oti{FECEncodingID: fecEncodingID, FECInstanceID: fecInstanceID}
+	switch fecEncodingID {
+	case FECEncodingRaptorQ:
+		if len(data) < 12 {
+			return o, fmt.Errorf("RaptorQ OTI too short: %d", len(data))
+		}
+		common := binary.BigEndian.Uint64(data[0:8])
+		o.TransferLength = common >> 24
+		o.EncodingSymbolLength = uint16(common)
+		o.SourceBlocks = uint16(data[8])
+		o.SubBlocks = binary.BigEndian.Uint16(data[9:11])
+		o.SymbolAlignment = data[11]
+	case FECEncodingReedSolomon:
+		if len(data) < 16 {
+			return o, fmt.Errorf("Reed-Solomon OTI too short: %d", len(data))
+		}
+		if data[10] != ReedSolomonM || data[11] != ReedSolomonSymbolsPerPkt {
+			return o, fmt.Errorf("unsupported Reed-Solomon parameters m=%d G=%d", data[10], data[11])
+		}
+		o.TransferLength = binary.BigEndian.Uint64(data[0:8]) >> 16
+		o.EncodingSymbolLength = binary.BigEndian.Uint16(data[8:10])
+		o.MaximumSourceBlockLength = uint32(binary.BigEndian.Uint16(data[12:14]))
+		o.MaxEncodingSymbols = uint32(binary.BigEndian.Uint16(data[14:16]))
+	case FECEncodingLDPCStaircase:
+		if len(data) < 24 {
+			return o, fmt.Errorf("LDPC-Staircase OTI too short: %d", len(data))
+		}
+		if data[11] != LDPCSymbolsPerPkt {
+			return o, fmt.Errorf("unsupported LDPC-Staircase parameter G=%d", data[11])
+		}
+		o.TransferLength = binary.BigEndian.Uint64(data[0:8]) >> 16
+		o.EncodingSymbolLength = binary.BigEndian.Uint16(data[8:10])
+		o.N1 = data[10] + LDPCMinN1
+		o.MaximumSourceBlockLength = binary.BigEndian.Uint32(data[12:16])
+		o.MaxEncodingSymbols = binary.BigEndian.Uint32(data[16:20])
+		o.PRNGSeed = binary.BigEndian.Uint32(data[20:24])
+	default:
+		if len(data) < 14 {
+			return o, fmt.Errorf("OTI too short: %d", len(data))
+		}
+		o.TransferLength = binary.BigEndian.Uint64(data[0:8]) >> 16
+		o.EncodingSymbolLength = binary.BigEndian.Uint16(data[8:10])
+		o.MaximumSourceBlockLength = binary.BigEndian.Uint32(data[10:14])
+	}
+	return o, nil
+}
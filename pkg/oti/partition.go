@@ -0,0 +1,272 @@
+package oti
+
+import "fmt"
+
+// RFC 6330 参数上限
+const (
+	raptorQMaxSourceSymbols  = 56403        // K'max，单个源块最多源符号数
+	raptorQMaxSourceBlocks   = 255          // Z 为 8 位字段
+	raptorQMaxTransferLength = 946270874880 // F 上限（字节）
+)
+
+// Compact No-Code 参数上限，SBN 与 ESI 均为 16 位（RFC 5445 3.2.1）
+const (
+	noCodeMaxSourceSymbols = 1 << 16
+	noCodeMaxSourceBlocks  = 1 << 16
+
+	reedSolomonMaxSourceBlocks = 1 << 24 // SBN 为 32 - m 位
+	ldpcMaxSourceBlocks        = 1 << 12 // SBN 为 12 位（RFC 5170）
+)
+
+// SourceBlock 描述对象中的一个源块
+type SourceBlock struct {
+	SBN     uint32
+	Offset  uint64 // 源块在对象中的起始字节
+	Length  uint64 // 源块的有效字节数，最后一个源块可能不足 Symbols*T
+	Symbols uint32 // 源符号数 K
+}
+
+// partition 即 RFC 6330 4.4.1.2 中的 Partition[I, J]，
+// 将 I 尽量均分为 J 份：JL 份大小为 IL，JS 份大小为 IS
+func partition(i, j uint64) (il, is, jl, js uint64) {
+	il = (i + j - 1) / j
+	is = i / j
+	jl = i - is*j
+	js = j - jl
+	return
+}
+
+// WithTransferLength 按 RFC 6330 4.4.1.2 为长度为 transferLength 的对象计算
+// 源块数 Z、子块数 N 和符号对齐 Al，返回该对象使用的 OTI
+func (o Oti) WithTransferLength(transferLength uint64) (Oti, error) {
+	o.TransferLength = transferLength
+	T := uint64(o.EncodingSymbolLength)
+	if T == 0 {
+		return o, fmt.Errorf("invalid encoding symbol length: 0")
+	}
+
+	if o.FECEncodingID == FECEncodingNoCode {
+		return o.withNoCodeBlocking()
+	}
+	if o.FECEncodingID == FECEncodingReedSolomon {
+		return o.withMaxNBlocking("Reed-Solomon", reedSolomonMaxEncSymbols, reedSolomonMaxSourceBlocks)
+	}
+	if o.FECEncodingID == FECEncodingLDPCStaircase {
+		return o.withLDPCBlocking()
+	}
+	if o.FECEncodingID != FECEncodingRaptorQ {
+		return o, nil
+	}
+
+	if transferLength > raptorQMaxTransferLength {
+		return o, fmt.Errorf("transfer length %d exceeds RaptorQ limit %d", transferLength, uint64(raptorQMaxTransferLength))
+	}
+
+	o.SymbolAlignment = defaultSymbolAlignment
+	if T%uint64(o.SymbolAlignment) != 0 {
+		o.SymbolAlignment = 1
+	}
+	Al := uint64(o.SymbolAlignment)
+
+	kMax := uint64(o.MaximumSourceBlockLength)
+	if kMax == 0 || kMax > raptorQMaxSourceSymbols {
+		kMax = raptorQMaxSourceSymbols
+	}
+
+	Kt := (transferLength + T - 1) / T
+	if Kt == 0 {
+		Kt = 1
+	}
+	Z := (Kt + kMax - 1) / kMax
+	if Z > raptorQMaxSourceBlocks {
+		// 源块数超出 8 位上限时增大单个源块，直到 K'max
+		Z = raptorQMaxSourceBlocks
+		if (Kt+Z-1)/Z > raptorQMaxSourceSymbols {
+			return o, fmt.Errorf("object of %d bytes needs more than %d source blocks of %d symbols, increase encoding symbol length",
+				transferLength, raptorQMaxSourceBlocks, raptorQMaxSourceSymbols)
+		}
+	}
+
+	N := uint64(1)
+	if WS := uint64(o.MaxSubBlockSize); WS > 0 {
+		KL := (Kt + Z - 1) / Z
+		N = (KL*T + WS - 1) / WS
+		if N > T/Al {
+			N = T / Al
+		}
+	}
+
+	o.SourceBlocks = uint16(Z)
+	o.SubBlocks = uint16(N)
+	return o, nil
+}
+
+// withNoCodeBlocking 检查 Compact No-Code 对象按 RFC 5052 9.1 分块后
+// 源块数与源块长度不超出 16 位的 SBN 与 ESI
+func (o Oti) withNoCodeBlocking() (Oti, error) {
+	B := uint64(o.MaximumSourceBlockLength)
+	if B == 0 || B > noCodeMaxSourceSymbols {
+		return o, fmt.Errorf("invalid maximum source block length %d for Compact No-Code (1..%d)", B, noCodeMaxSourceSymbols)
+	}
+	T := uint64(o.EncodingSymbolLength)
+	Kt := (o.TransferLength + T - 1) / T
+	if Z := (Kt + B - 1) / B; Z > noCodeMaxSourceBlocks {
+		return o, fmt.Errorf("object of %d bytes needs %d source blocks, Compact No-Code allows %d; increase encoding symbol length or max source block length",
+			o.TransferLength, Z, noCodeMaxSourceBlocks)
+	}
+	return o, nil
+}
+
+// withMaxNBlocking 检查以 max_n 限定码率的方案（Reed-Solomon、LDPC-Staircase）参数满足
+// B <= max_n <= maxEncSymbols，且按 RFC 5052 9.1 分块后源块数不超出 SBN 字段（RFC 5510 5.1）
+func (o Oti) withMaxNBlocking(name string, maxEncSymbols, maxSourceBlocks uint64) (Oti, error) {
+	B := uint64(o.MaximumSourceBlockLength)
+	maxN := uint64(o.MaxEncodingSymbols)
+	if B == 0 || B > maxN || maxN > maxEncSymbols {
+		return o, fmt.Errorf("invalid %s parameters B=%d max_n=%d (need 0 < B <= max_n <= %d)", name, B, maxN, maxEncSymbols)
+	}
+	T := uint64(o.EncodingSymbolLength)
+	Kt := (o.TransferLength + T - 1) / T
+	if Z := (Kt + B - 1) / B; Z > maxSourceBlocks {
+		return o, fmt.Errorf("object of %d bytes needs %d source blocks, %s allows %d; increase encoding symbol length",
+			o.TransferLength, Z, name, maxSourceBlocks)
+	}
+	return o, nil
+}
+
+// withLDPCBlocking 在 withMaxNBlocking 的基础上检查 LDPC-Staircase 的 N1 与 PRNG 种子
+func (o Oti) withLDPCBlocking() (Oti, error) {
+	if o.N1 < LDPCMinN1 || o.N1 > ldpcMaxN1 {
+		return o, fmt.Errorf("invalid LDPC-Staircase N1 %d (%d..%d)", o.N1, LDPCMinN1, ldpcMaxN1)
+	}
+	if o.PRNGSeed == 0 || o.PRNGSeed > ldpcMaxSeed {
+		return o, fmt.Errorf("invalid LDPC-Staircase PRNG seed %d (1..%d)", o.PRNGSeed, ldpcMaxSeed)
+	}
+	return o.withMaxNBlocking("LDPC-Staircase", LDPCMaxEncSymbols, ldpcMaxSourceBlocks)
+}
+
+// Validate 检查从 FDT 或 EXT_FTI 收到的 OTI 未超出方案的参数上限（SBN 与 ESI 的字段宽度等），
+// 与发送端 WithTransferLength 的检查一致。接收端按 OTI 分配源块表之前调用
+func (o Oti) Validate() error {
+	if o.EncodingSymbolLength == 0 {
+		return fmt.Errorf("invalid encoding symbol length: 0")
+	}
+
+	var err error
+	switch o.FECEncodingID {
+	case FECEncodingNoCode:
+		_, err = o.withNoCodeBlocking()
+	case FECEncodingReedSolomon:
+		_, err = o.withMaxNBlocking("Reed-Solomon", reedSolomonMaxEncSymbols, reedSolomonMaxSourceBlocks)
+	case FECEncodingLDPCStaircase:
+		_, err = o.withLDPCBlocking()
+	case FECEncodingRaptorQ:
+		err = o.validateRaptorQ()
+	}
+	return err
+}
+
+// validateRaptorQ 检查 RaptorQ 的 F、Z、N 与 Al：Z 不超出 8 位字段，每个源块不超过 K'max 个源符号，
+// 子块划分有效（RFC 6330 4.4.1.2）
+func (o Oti) validateRaptorQ() error {
+	if o.TransferLength > raptorQMaxTransferLength {
+		return fmt.Errorf("transfer length %d exceeds RaptorQ limit %d", o.TransferLength, uint64(raptorQMaxTransferLength))
+	}
+	Kt, Z, err := o.Blocking()
+	if err != nil {
+		return err
+	}
+	if Z > raptorQMaxSourceBlocks {
+		return fmt.Errorf("invalid RaptorQ source block count %d (1..%d)", Z, raptorQMaxSourceBlocks)
+	}
+	if K := (Kt + Z - 1) / Z; K > raptorQMaxSourceSymbols {
+		return fmt.Errorf("RaptorQ source block of %d symbols exceeds K'max %d", K, raptorQMaxSourceSymbols)
+	}
+	if _, err := o.SubSymbolSizes(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Blocking 返回对象的源符号总数 Kt 与源块数 Z，不分配源块划分
+func (o Oti) Blocking() (uint64, uint64, error) {
+	T := uint64(o.EncodingSymbolLength)
+	if T == 0 {
+		return 0, 0, fmt.Errorf("invalid encoding symbol length: 0")
+	}
+
+	Kt := (o.TransferLength + T - 1) / T
+	if Kt == 0 {
+		Kt = 1
+	}
+
+	var Z uint64
+	if o.FECEncodingID == FECEncodingRaptorQ {
+		Z = uint64(o.SourceBlocks)
+	} else if o.MaximumSourceBlockLength > 0 {
+		Z = (Kt + uint64(o.MaximumSourceBlockLength) - 1) / uint64(o.MaximumSourceBlockLength)
+	} else {
+		Z = 1
+	}
+	if Z == 0 || Z > Kt {
+		return 0, 0, fmt.Errorf("invalid source block count %d for %d source symbols", Z, Kt)
+	}
+	return Kt, Z, nil
+}
+
+// Partition 返回对象的源块划分。RaptorQ 按 RFC 6330 由 Z 划分，
+// 其余方案按 RFC 5052 9.1 的分块算法以 MaximumSourceBlockLength 切分（0 表示整个对象为一个源块）
+func (o Oti) Partition() ([]SourceBlock, error) {
+	Kt, Z, err := o.Blocking()
+	if err != nil {
+		return nil, err
+	}
+	T := uint64(o.EncodingSymbolLength)
+
+	KL, KS, ZL, _ := partition(Kt, Z)
+	blocks := make([]SourceBlock, 0, Z)
+	var offset uint64
+	for sbn := uint64(0); sbn < Z; sbn++ {
+		K := KS
+		if sbn < ZL {
+			K = KL
+		}
+		length := K * T
+		if offset+length > o.TransferLength {
+			length = o.TransferLength - offset
+		}
+		blocks = append(blocks, SourceBlock{
+			SBN:     uint32(sbn),
+			Offset:  offset,
+			Length:  length,
+			Symbols: uint32(K),
+		})
+		offset += length
+	}
+
+	return blocks, nil
+}
+
+// SubSymbolSizes 返回每个子块的子符号长度，所有子符号首尾相接构成一个编码符号
+func (o Oti) SubSymbolSizes() ([]uint32, error) {
+	N := uint64(o.SubBlocks)
+	if N <= 1 {
+		return []uint32{uint32(o.EncodingSymbolLength)}, nil
+	}
+
+	Al := uint64(o.SymbolAlignment)
+	if Al == 0 || uint64(o.EncodingSymbolLength)%Al != 0 || N > uint64(o.EncodingSymbolLength)/Al {
+		return nil, fmt.Errorf("invalid sub-block parameters: T=%d N=%d Al=%d", o.EncodingSymbolLength, N, Al)
+	}
+
+	TL, TS, NL, _ := partition(uint64(o.EncodingSymbolLength)/Al, N)
+	sizes := make([]uint32, N)
+	for j := uint64(0); j < N; j++ {
+		if j < NL {
+			sizes[j] = uint32(TL * Al)
+		} else {
+			sizes[j] = uint32(TS * Al)
+		}
+	}
+	return sizes, nil
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// 未指定桶容量时按 10ms 的发送量计算，且至少容纳一个以太网帧
+const (
+	defaultBurstWindow = 10 * time.Millisecond
+	minBurstBytes      = 1500
+)
+
+// TokenBucket 为按字节计量的令牌桶。令牌以 rate 匀速补充，桶中最多积累 burst 字节，
+// 空闲后最多突发 burst 字节，之后每个包按 rate 均匀间隔发出
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // 字节/秒
+	burst  float64 // 桶容量（字节）
+	tokens float64 // 可为负，表示已透支、需要等待的字节数
+	last   time.Time
+}
+
+// NewTokenBucket 创建速率为 bitsPerSecond、桶容量为 burstBytes 的令牌桶，
+// burstBytes 为 0 时使用 10ms 的发送量；bitsPerSecond 为 0 时返回 nil，表示不限速
+func NewTokenBucket(bitsPerSecond, burstBytes uint64) *TokenBucket {
+	if bitsPerSecond == 0 {
+		return nil
+	}
+	rate := float64(bitsPerSecond) / 8
+	burst := float64(burstBytes)
+	if burst == 0 {
+		burst = max(rate*defaultBurstWindow.Seconds(), minBurstBytes)
+	}
+	return &TokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Wait 取出 n 字节的令牌，令牌不足时阻塞到按速率补足为止。nil 令牌桶不限速
+func (b *TokenBucket) Wait(n int) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, b.burst)
+	b.last = now
+	// 先透支再等待，sleep 的误差会在后续的包中得到补偿，长期速率不受影响
+	b.tokens -= float64(n)
+	deficit := -b.tokens
+	b.mu.Unlock()
+
+	if deficit > 0 {
+		time.Sleep(time.Duration(deficit / b.rate * float64(time.Second)))
+	}
+}
+
+// Rate 返回速率（bit/s）
+func (b *TokenBucket) Rate() uint64 {
+	if b == nil {
+		return 0
+	}
+	return uint64(b.rate * 8)
+}
+
+// Burst 返回桶容量（字节）
+func (b *TokenBucket) Burst() uint64 {
+	if b == nil {
+		return 0
+	}
+	return uint64(b.burst)
+}
@@ -3,21 +3,43 @@ package sender
 import (
 	alc "FluteTest/pkg/alc"
 	fdt "FluteTest/pkg/fdt"
+	fec "FluteTest/pkg/fec"
 	fd "FluteTest/pkg/filedesc"
 	lct "FluteTest/pkg/lct"
 	oti "FluteTest/pkg/oti"
+	ratelimit "FluteTest/pkg/ratelimit"
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
-	"math"
+	"hash"
+	"io"
 	"net"
+	"strconv"
+	"strings"
 	"time"
-
-	raptorq "github.com/xssnick/raptorq"
 )
 
+// FDT 实例号为 20 位
+const maxFdtInstanceID = 1<<20 - 1
+
+// 保留的源块缓冲区个数：正在发送的对象与期间补发的 FDT 各占一个
+const blockBufferPoolSize = 2
+
+// 为完整源块规划修复符号数时的上限（LDPC-Staircase 的 20 位 ESI 空间）
+const maxPlannedRepairSymbols = 1 << 20
+
 type SenderConfig struct {
-	FdtDuration time.Duration
+	FdtDuration time.Duration // FDT 重复发送间隔
+	FdtExpires  time.Duration // FDT 实例有效期
 	SymbolSize  uint32
 	FdtStartID  uint32
+	Rate        uint64 // 发送速率（bit/s，按 UDP 载荷计算），0 表示不限速
+	Burst       uint64 // 令牌桶容量（字节），0 表示按 10ms 的发送量
+	// 会话默认的修复冗余，文件可通过 FileDesc.RepairOverhead 单独指定；
+	// 实际发送的修复符号数不超过 FEC 方案支持的上限（no-code 为 0）
+	RepairOverhead fec.RepairOverhead
 }
 
 type FileConfig struct {
@@ -28,239 +50,507 @@ type FileConfig struct {
 
 type Sender struct {
 	Conn         *net.UDPConn
-	Fdt          fdt.ExtFDT
+	FdtInstance  *fdt.Instance // 描述本会话所有文件的 FDT 实例，以 TOI 0 发送
 	TSI          uint32
 	OTI          oti.Oti
 	SenderConfig SenderConfig
 	FileConfig   FileConfig
-	RQ           raptorq.RaptorQ
 	nextFdtID    uint32
-	nextTSI      uint32
+	nextTOI      uint64
+	lastFdtTime  time.Time
+	limiter      *ratelimit.TokenBucket // 会话内所有数据包（包括 FDT）共用，nil 表示不限速
+	buffers      *bufferPool            // 源块读取缓冲区
 }
 
-func NewSender(conn *net.UDPConn, fdtMeta *fdt.ExtFDT, TSI uint32, oti oti.Oti, fileCfg *FileConfig, sendCfg SenderConfig, rq *raptorq.RaptorQ) *Sender {
-	var meta fdt.ExtFDT
-	if fdtMeta != nil {
-		meta = *fdtMeta
-	}
-
+func NewSender(conn *net.UDPConn, TSI uint32, oti oti.Oti, fileCfg *FileConfig, sendCfg SenderConfig) *Sender {
 	var cfg FileConfig
 	if fileCfg != nil {
 		cfg = *fileCfg
 	}
 
-	var encoder raptorq.RaptorQ
-	if rq != nil {
-		encoder = *rq
-	}
-
 	startID := sendCfg.FdtStartID
-	if startID == 0 {
+	if startID == 0 || startID > maxFdtInstanceID {
 		startID = 1
 	}
 
+	limiter := ratelimit.NewTokenBucket(sendCfg.Rate, sendCfg.Burst)
+	if limiter != nil {
+		fmt.Printf("Rate limit: %d bit/s, burst %d bytes\n", limiter.Rate(), limiter.Burst())
+	}
+
 	return &Sender{
 		Conn:         conn,
-		Fdt:          meta,
 		TSI:          TSI,
 		OTI:          oti,
 		SenderConfig: sendCfg,
 		FileConfig:   cfg,
-		RQ:           encoder,
 		nextFdtID:    startID,
-		nextTSI:      TSI,
+		nextTOI:      1, // TOI 0 保留给 FDT
+		limiter:      limiter,
+		buffers:      newBufferPool(blockBufferPoolSize),
 	}
 }
 
-func (s *Sender) Encode(data []byte) ([]byte, error) {
-	blockEncoder, err := s.RQ.CreateEncoder(data)
+// AddFile 为文件分配 TOI 并登记到会话的 FDT 实例中，FDT 内容变化后实例号递增。
+// 目录以长度为 0、Content-Location 以 "/" 结尾的条目登记，供接收端重建空目录
+func AddFile(s *Sender, filedesc *fd.FileDesc) error {
+	// 设置 senderCfg symbolSize
+	s.SenderConfig.SymbolSize = uint32(s.OTI.EncodingSymbolLength)
+
+	if filedesc.TOI == 0 {
+		filedesc.TOI = s.nextTOI
+		s.nextTOI++
+	} else if filedesc.TOI >= s.nextTOI {
+		s.nextTOI = filedesc.TOI + 1
+	}
+
+	objectOti, _, err := s.fileOTI(filedesc, uint64(filedesc.Size))
 	if err != nil {
-		return nil, fmt.Errorf("create RaptorQ encoder failed: %w", err)
+		return fmt.Errorf("calculate OTI for %s failed: %w", filedesc.Path, err)
 	}
 
-	raw := blockEncoder.GenSymbol(0)
+	location := filedesc.Name
+	if filedesc.Directory {
+		location = strings.TrimSuffix(location, "/") + "/"
+	}
+	// Content-Length 为内容解码后的长度，有内容编码且长度未知时不携带；传输长度由 Transfer-Length 给出
+	contentLength := uint64(filedesc.Size)
+	if filedesc.ContentEncoding != "" {
+		contentLength = uint64(max(filedesc.ContentLength, 0))
+	}
+	file := fdt.File{
+		ContentLocation: location,
+		TOI:             strconv.FormatUint(filedesc.TOI, 10),
+		ContentLength:   contentLength,
+		ContentType:     filedesc.ContentType,
+		ContentEncoding: filedesc.ContentEncoding,
+	}
+	if file.ContentMD5, err = hexToBase64(filedesc.Md5); err != nil {
+		return fmt.Errorf("invalid MD5 %q for %s: %w", filedesc.Md5, filedesc.Path, err)
+	}
+	if file.ContentSHA256, err = hexToBase64(filedesc.Sha256); err != nil {
+		return fmt.Errorf("invalid SHA-256 %q for %s: %w", filedesc.Sha256, filedesc.Path, err)
+	}
+	file.SetOTI(objectOti)
 
-	return raw, nil
+	instance := fdt.NewInstance(s.nextFdtID, time.Now().Add(s.fdtExpires()))
+	if s.FdtInstance != nil {
+		instance.Files = append(instance.Files, s.FdtInstance.Files...)
+	}
+	instance.Files = append(instance.Files, file)
+	instance.Complete = false
+	s.FdtInstance = instance
+
+	filedesc.FdtID = s.nextFdtID
+	s.advanceFdtID()
+	return nil
 }
 
-func AddFile(s *Sender, filedesc *fd.FileDesc) {
-	// 设置 senderCfg symbolSize
-	s.SenderConfig.SymbolSize = uint32(s.OTI.EncodingSymbolLength)
+func (s *Sender) fdtExpires() time.Duration {
+	if s.SenderConfig.FdtExpires <= 0 {
+		return time.Hour
+	}
+	return s.SenderConfig.FdtExpires
+}
 
-	if filedesc.FdtID == 0 {
-		filedesc.FdtID = s.nextFdtID
-		s.nextFdtID++
-	} else if filedesc.FdtID >= s.nextFdtID {
-		s.nextFdtID = filedesc.FdtID + 1
+func (s *Sender) advanceFdtID() {
+	s.nextFdtID++
+	if s.nextFdtID > maxFdtInstanceID {
+		s.nextFdtID = 0
 	}
+}
 
-	s.TSI = s.nextTSI
-	s.nextTSI++
+// renewFDT 在 FDT 实例剩余有效期不足一半时以新的实例号和 Expires 重新生成实例，
+// 避免长时间轮播时接收端因实例过期而丢弃 FDT
+func (s *Sender) renewFDT() {
+	expires := s.fdtExpires()
+	if at, err := s.FdtInstance.ExpiresAt(); err == nil && time.Until(at) > expires/2 {
+		return
+	}
+	instance := fdt.NewInstance(s.nextFdtID, time.Now().Add(expires))
+	instance.Files = s.FdtInstance.Files
+	instance.Complete = s.FdtInstance.Complete
+	s.FdtInstance = instance
+	s.advanceFdtID()
+}
 
-	// 设置 sender fileCfg
-	fileCfg := s.FileConfig
-	fileCfg.FileName = filedesc.Name
-	fileCfg.FilePath = filedesc.Path
-	fileCfg.ContentType = filedesc.ContentType
-	s.FileConfig = fileCfg
-
-	// 设置 FDT
-	s.Fdt.FileName = filedesc.Name
-	s.Fdt.ContentType = filedesc.ContentType
-	s.Fdt.FDTInstanceID = filedesc.FdtID
+// fileOTI 返回文件使用的修复冗余与 OTI。Reed-Solomon 与 LDPC-Staircase 的 max_n 按完整源块
+// 所需的修复符号数设置，因此同一会话中不同冗余的文件可能有不同的 B 与 max_n
+func (s *Sender) fileOTI(filedesc *fd.FileDesc, size uint64) (oti.Oti, fec.RepairOverhead, error) {
+	overhead := s.SenderConfig.RepairOverhead
+	if filedesc.RepairOverhead != nil {
+		overhead = *filedesc.RepairOverhead
+	}
+
+	base := s.OTI
+	if scheme, ok := fec.Lookup(base.FECEncodingID); ok {
+		base = planRepair(base, scheme, overhead)
+	}
+
+	objectOti, err := base.WithTransferLength(size)
+	return objectOti, overhead, err
 }
 
-func (s *Sender) Send(fileData *[]byte) error {
+// planRepair 为以 max_n 限定码率的方案（Reed-Solomon、LDPC-Staircase）选择源块长度 B 与 max_n：
+// B 取不超过配置值、且加上按 B 本身计算的修复符号数后不超过方案上限的最大值，
+// 使完整源块的实际修复比例与配置一致。修复符号数随 K 单调不减，因此可以二分查找
+func planRepair(o oti.Oti, scheme fec.FECScheme, overhead fec.RepairOverhead) oti.Oti {
+	fit := func(B uint32) (oti.Oti, bool) {
+		o := o
+		o.MaximumSourceBlockLength = B
+		r := o.WithRepairSymbols(overhead.RepairSymbols(scheme, B, maxPlannedRepairSymbols))
+		return r, r.MaximumSourceBlockLength == B
+	}
+	if o.MaximumSourceBlockLength <= 1 {
+		r, _ := fit(o.MaximumSourceBlockLength)
+		return r
+	}
+	if r, ok := fit(o.MaximumSourceBlockLength); ok {
+		return r
+	}
+	// 修复符号数受上限截断，B = 1 总能放下；lo 满足要求，hi 不满足
+	lo, hi := uint32(1), o.MaximumSourceBlockLength
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		if _, ok := fit(mid); ok {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	r, _ := fit(lo)
+	return r
+}
+
+// hexToBase64 将十六进制摘要转换为 FDT 使用的 base64 编码，空串保持为空
+func hexToBase64(digest string) (string, error) {
+	if digest == "" {
+		return "", nil
+	}
+	raw, err := hex.DecodeString(digest)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// CompleteFDT 标记 FDT 实例已列出本会话的全部文件（Complete="true"）
+func (s *Sender) CompleteFDT() {
+	if s.FdtInstance != nil {
+		s.FdtInstance.Complete = true
+	}
+}
+
+// Send 发送已通过 AddFile 登记的文件，src 为文件内容，长度为 filedesc.Size
+func (s *Sender) Send(filedesc *fd.FileDesc, src io.ReaderAt) error {
+	return s.SendRound(filedesc, src, 0, true)
+}
+
+// SendReader 从只能顺序读取的 r（如管道）发送已登记的文件，只能发送一轮
+func (s *Sender) SendReader(filedesc *fd.FileDesc, r io.Reader) error {
+	return s.SendRound(filedesc, &sequentialReaderAt{r: r}, 0, true)
+}
+
+// SendRound 在轮播的第 round 轮（从 0 开始）发送文件。第 0 轮发送源符号与修复符号；
+// 之后各轮对能生成足够多新修复符号的方案（如 RaptorQ）发送同样数量的新修复符号，
+// 使晚加入的接收端每一轮都能收到有用的符号，其余方案重复第 0 轮的符号。
+// lastRound 为 true 表示之后不再发送该文件，最后一个数据包设置 Close Object 标志（B 位）。
+// 文件按源块逐块读取与编码，发送过程中同时计算 MD5，与 FDT 中登记的摘要不符时返回错误
+func (s *Sender) SendRound(filedesc *fd.FileDesc, src io.ReaderAt, round uint32, lastRound bool) error {
 
 	if s.Conn == nil {
 		return fmt.Errorf("sender UDP connection is nil")
 	}
 
 	startTime := time.Now()
-	lastTime := time.Now()
-
-	oti := s.OTI
 
-	// 是否进行 FEC 编码
-	shouldEncode := (oti.FECEncodingID != 0)
-
-	var cci uint8 = 0
-	var tsi uint32 = s.TSI
-	var toi uint32 = s.Fdt.FDTInstanceID
+	if s.SenderConfig.SymbolSize == 0 {
+		return fmt.Errorf("invalid symbol size: 0")
+	}
 
-	fdtDur := s.SenderConfig.FdtDuration
+	// 设置 sender fileCfg
+	s.FileConfig.FileName = filedesc.Name
+	s.FileConfig.FilePath = filedesc.Path
+	s.FileConfig.ContentType = filedesc.ContentType
+
+	if filedesc.Size == 0 {
+		// 零长度文件没有数据，接收端收到 FDT 中的描述即创建
+		fmt.Printf("File %s is empty, described by FDT only\n", s.FileConfig.FilePath)
+		return nil
+	}
 
-	ChunkSize := int(s.SenderConfig.SymbolSize)
-	if ChunkSize <= 0 {
-		return fmt.Errorf("invalid symbol size: %d", ChunkSize)
+	// 根据文件大小计算本对象的源块划分参数
+	objectOti, overhead, err := s.fileOTI(filedesc, uint64(filedesc.Size))
+	if err != nil {
+		return fmt.Errorf("calculate OTI for %s failed: %w", s.FileConfig.FilePath, err)
 	}
-	totalChunks := uint32(math.Ceil(float64(len(*fileData)) / float64(ChunkSize)))
 
-	sendCloseSession := false
+	digest := md5.New()
+	if err := s.sendObject(filedesc.TOI, src, objectOti, overhead, round, lastRound, nil, digest); err != nil {
+		return err
+	}
+	md5sum := hex.EncodeToString(digest.Sum(nil))
+	if filedesc.Md5 != "" && md5sum != filedesc.Md5 {
+		return fmt.Errorf("%s changed while sending: MD5 %s, FDT announced %s", s.FileConfig.FilePath, md5sum, filedesc.Md5)
+	}
 
-	// Send chunks
-	for i := 0; i < len(*fileData); i += ChunkSize {
-		serverTime := time.Now()
-		end := i + ChunkSize
-		if end > len(*fileData) {
-			end = len(*fileData)
-		}
-		chunkData := (*fileData)[i:end]
-		chunkLen := uint32(len(chunkData))
-
-		isLastChunk := (end == len(*fileData))
-		closeObject := isLastChunk
-		closeSession := false
-
-		data := chunkData
-		cp := uint8(0) // 0: 原始数据 No-code
-		if shouldEncode {
-			encodedSymbol, err := s.Encode(chunkData)
-			if err != nil {
-				return fmt.Errorf("encode chunk data failed: %w", err)
-			}
-			data = encodedSymbol
-			cp = 1 // 1: 编码数据 RaptorQ
-		}
+	timeSpent := time.Since(startTime)
+	fmt.Printf("File %s sent in %v (MD5 %s)\n", s.FileConfig.FilePath, timeSpent, md5sum)
+	return nil
+}
 
-		lcth := lct.LCTHeader{
-			Version:      1,
-			Flags:        alc.CalculateFlags(closeObject, closeSession, true),
-			CCI:          cci, // 无速率控制
-			TSI:          tsi,
-			TOI:          toi,
-			CloseObject:  closeObject,
-			CloseSession: closeSession,
-			CodePoint:    cp, // 直接传输原始数据或编码数据
-		}
+// 关闭会话包的发送次数，重复发送以降低全部丢失的概率；全部丢失时接收端按会话空闲超时结束会话
+const closeSessionRepeat = 3
 
-		fmt.Printf("LCT Header param: Flags(%d), CCI(%d), TSI(%d), TOI(%d)\n", lcth.Flags, lcth.CCI, lcth.TSI, lcth.TOI)
-
-		// FDT
-		packetFDT := s.Fdt
-
-		// Create ALC packet
-		pkt := &alc.AlcPkt{
-			LCTHeader:       lcth,
-			OTI:             oti,
-			SourceBlockNb:   uint32(i / ChunkSize),
-			EncodingSymbol:  uint32(totalChunks),
-			EncodingSymbols: data,
-			TotalChunks:     uint32(totalChunks),
-			PayloadLength:   chunkLen,
-			TransferLength:  uint64(len(data)),
-			ServerTime:      serverTime,
-			FDT:             packetFDT,
+// CloseSession 发送关闭会话包（LCT 头部 A 位置 1），通知接收端本会话不再发送数据，
+// 接收端随即保存已完成的对象并隔离未完成的对象
+func (s *Sender) CloseSession() error {
+	if s.Conn == nil {
+		return fmt.Errorf("sender UDP connection is nil")
+	}
+	closePkt, err := alc.NewAlcPktCloseSession(s.OTI, 0, uint64(s.TSI))
+	if err != nil {
+		return fmt.Errorf("build close session packet failed: %w", err)
+	}
+	for i := 0; i < closeSessionRepeat; i++ {
+		s.limiter.Wait(len(closePkt))
+		if _, err := s.Conn.Write(closePkt); err != nil {
+			return fmt.Errorf("write close session packet failed: %w", err)
 		}
+	}
+	fmt.Printf("Close session packet sent (TSI %d)\n", s.TSI)
+	return nil
+}
 
-		// 日志输出
-		fmt.Printf("Pkt param: SourceBlockNb(%d), EncodingSymbol(%d), TotalChunks(%d), TransferLength(%d), ServerTime(%v)\n",
-			pkt.SourceBlockNb, pkt.EncodingSymbol, pkt.TotalChunks, pkt.TransferLength, pkt.ServerTime)
+// sendObject 按 OTI 将对象划分为源块，每次从 src 读入一个源块，由 FEC Encoding ID 对应的方案编码，
+// 依次发送全部 K 个源符号（ESI 0..K-1）以及按 overhead 计算的修复符号（ESI K..），
+// 轮播的后续轮次按 roundESI 选择发送的 ESI，exts 为每个数据包附加的头部扩展。
+// closeObject 为 true 时对象的最后一个数据包设置 Close Object 标志。读入的数据同时写入 digest（可为 nil）
+func (s *Sender) sendObject(toi uint64, src io.ReaderAt, objectOti oti.Oti, overhead fec.RepairOverhead, round uint32,
+	closeObject bool, exts []lct.Extension, digest hash.Hash) error {
+	scheme, ok := fec.Lookup(objectOti.FECEncodingID)
+	if !ok {
+		return fmt.Errorf("unsupported FEC encoding ID %d", objectOti.FECEncodingID)
+	}
+	blocks, err := objectOti.Partition()
+	if err != nil {
+		return fmt.Errorf("partition object failed: %w", err)
+	}
 
-		packet := pkt.Serialize()
-		if len(packet) > 65507 {
-			fmt.Printf("Packet size %d exceeds UDP limit\n", len(packet))
-			continue
-		}
+	totalBlocks := uint32(len(blocks))
+	fmt.Printf("%s partition: F=%d T=%d Z=%d N=%d Al=%d, repair overhead %v\n", scheme.Name(), objectOti.TransferLength,
+		objectOti.EncodingSymbolLength, totalBlocks, objectOti.SubBlocks, objectOti.SymbolAlignment, overhead)
 
-		_, err := s.Conn.Write(packet)
+	var sourceTotal, repairTotal uint64
+	for _, sb := range blocks {
+		repairSymbols, err := s.sendBlock(toi, src, scheme, objectOti, sb, closeObject && sb.SBN == totalBlocks-1, overhead, round, exts, digest)
 		if err != nil {
-			fmt.Println("Write to UDP failed:", err)
 			return err
 		}
+		sourceTotal += uint64(sb.Symbols)
+		repairTotal += uint64(repairSymbols)
+	}
+
+	if sourceTotal > 0 {
+		fmt.Printf("TOI %d: %d source + %d repair symbols, repair bandwidth overhead %.2f%% (%d bytes)\n", toi, sourceTotal, repairTotal,
+			float64(repairTotal)*100/float64(sourceTotal), repairTotal*uint64(objectOti.EncodingSymbolLength))
+	}
+	return nil
+}
 
-		if fdtDur > 0 && serverTime.Sub(lastTime) >= fdtDur {
-			s.SendFDT()
+// sendBlock 读取并编码一个源块，发送该轮的源符号与修复符号，返回发送的修复符号数。
+// closeObject 为 true 时最后一个数据包设置 Close Object 标志；源块缓冲区在返回时归还
+func (s *Sender) sendBlock(toi uint64, src io.ReaderAt, scheme fec.FECScheme, objectOti oti.Oti, sb oti.SourceBlock, closeObject bool,
+	overhead fec.RepairOverhead, round uint32, exts []lct.Extension, digest hash.Hash) (uint32, error) {
+	block := s.buffers.get(int(sb.Length))
+	defer s.buffers.put(block)
+	if n, err := src.ReadAt(block, int64(sb.Offset)); n < len(block) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
 		}
+		return 0, fmt.Errorf("read source block %d (offset %d, %d bytes) failed: %w", sb.SBN, sb.Offset, sb.Length, err)
+	}
+	if digest != nil {
+		digest.Write(block)
+	}
+
+	encoder, err := scheme.NewEncoder(objectOti, sb, block)
+	if err != nil {
+		return 0, fmt.Errorf("encode source block %d failed: %w", sb.SBN, err)
+	}
 
-		lastTime = time.Now()
+	repairSymbols := overhead.RepairSymbols(scheme, sb.Symbols, encoder.MaxRepairSymbols())
+	totalSymbols := sb.Symbols + repairSymbols
+	if overhead.Auto {
+		probability := fec.DecodeProbability(totalSymbols, sb.Symbols+scheme.ReceptionOverhead(sb.Symbols), overhead.LossRate)
+		fmt.Printf("Source block %d: %d bytes, %d source symbols, %d repair symbols, expected decode probability %.6f\n",
+			sb.SBN, sb.Length, sb.Symbols, repairSymbols, probability)
+	} else {
+		fmt.Printf("Source block %d: %d bytes, %d source symbols, %d repair symbols\n",
+			sb.SBN, sb.Length, sb.Symbols, repairSymbols)
 	}
 
-	if sendCloseSession {
-		closePkt := alc.NewAlcPktCloseSession(oti, cci, tsi, toi)
-		_, err := s.Conn.Write(closePkt)
+	firstESI := roundESI(sb.Symbols, repairSymbols, encoder.MaxRepairSymbols(), round)
+	if firstESI > 0 {
+		fmt.Printf("Round %d: source block %d sends fresh repair symbols ESI %d..%d\n",
+			round, sb.SBN, firstESI, firstESI+totalSymbols-1)
+	}
+
+	for i := uint32(0); i < totalSymbols; i++ {
+		esi := firstESI + i
+		isLastSymbol := closeObject && i == totalSymbols-1
+		symbol, err := encoder.GenSymbol(esi)
 		if err != nil {
-			fmt.Println("Write close packet to UDP failed:", err)
-			return err
+			return 0, fmt.Errorf("generate symbol %d of block %d failed: %w", esi, sb.SBN, err)
+		}
+		pkt := s.newDataPkt(toi, objectOti, isLastSymbol, sb.SBN, esi, symbol)
+		pkt.Extensions = exts
+		if err := s.writeDataPkt(pkt); err != nil {
+			return 0, err
+		}
+	}
+	return repairSymbols, nil
+}
+
+// roundESI 返回轮播第 round 轮发送的第一个 ESI，该轮连续发送 K + repair 个符号。
+// 第 0 轮从 ESI 0 开始；之后若新的修复符号仍在方案支持的范围内，则接着上一轮发送未发过的修复符号，
+// 否则从 0 开始重复
+func roundESI(k, repair, maxRepair, round uint32) uint32 {
+	if round == 0 {
+		return 0
+	}
+	perRound := uint64(k) + uint64(repair)
+	first := uint64(round) * perRound
+	if first+perRound > uint64(k)+uint64(maxRepair) {
+		return 0
+	}
+	return uint32(first)
+}
+
+// newDataPkt 构造一个携带编码符号的 ALC 数据包。文件对象的名称、长度与 FEC 参数
+// 由 FDT 描述，数据包不再携带；只有 TOI 0（FDT 实例）通过 EXT_FTI 携带 OTI
+func (s *Sender) newDataPkt(toi uint64, objectOti oti.Oti, closeObject bool, sbn, esi uint32, data []byte) *alc.AlcPkt {
+	closeSession := false
+
+	lcth := lct.LCTHeader{
+		Version:      lct.Version,
+		CCI:          0, // 无速率控制
+		TSI:          uint64(s.TSI),
+		TOI:          toi,
+		CloseObject:  closeObject,
+		CloseSession: closeSession,
+		CodePoint:    objectOti.FECEncodingID, // FEC Encoding ID
+	}
+
+	pkt := &alc.AlcPkt{
+		LCTHeader:       lcth,
+		OTI:             objectOti,
+		WithFTI:         toi == 0,
+		SourceBlockNb:   sbn,
+		EncodingSymbol:  esi,
+		EncodingSymbols: data,
+		ServerTime:      time.Now(),
+	}
+	return pkt
+}
+
+// writeDataPkt 序列化并发送数据包，发送文件数据期间按 FdtDuration 周期性补发 FDT
+func (s *Sender) writeDataPkt(pkt *alc.AlcPkt) error {
+	packet, err := pkt.Serialize()
+	if err != nil {
+		return fmt.Errorf("serialize packet failed: %w", err)
+	}
+	if len(packet) > 65507 {
+		fmt.Printf("Packet size %d exceeds UDP limit\n", len(packet))
+		return nil
+	}
+
+	s.limiter.Wait(len(packet))
+	_, err = s.Conn.Write(packet)
+	if err != nil {
+		fmt.Println("Write to UDP failed:", err)
+		return err
+	}
+
+	fdtDur := s.SenderConfig.FdtDuration
+	if pkt.LCTHeader.TOI != 0 && fdtDur > 0 && time.Since(s.lastFdtTime) >= fdtDur {
+		if err := s.SendFDT(); err != nil {
+			fmt.Println("Send FDT failed:", err)
 		}
 	}
 
-	timeSpent := time.Since(startTime)
-	fmt.Printf("File %s sent in %v\n", s.FileConfig.FilePath, timeSpent)
 	return nil
 }
 
+// SendFDT 将当前 FDT 实例编码为 XML，作为 TOI 0 对象发送，
+// 每个数据包通过 EXT_FDT 携带 FDT 实例号
 func (s *Sender) SendFDT() error {
-	payload, err := s.Fdt.Marshal()
+	if s.FdtInstance == nil {
+		return fmt.Errorf("no FDT instance to send")
+	}
+	s.renewFDT()
+	payload, err := s.FdtInstance.Marshal()
 	if err != nil {
 		return fmt.Errorf("marshal FDT failed: %w", err)
 	}
+	s.lastFdtTime = time.Now()
 
-	fdtAlcPkt := alc.AlcPkt{
-		LCTHeader: lct.LCTHeader{
-			Version:      1,
-			Flags:        alc.CalculateFlags(true, false, true),
-			CCI:          0,
-			TSI:          s.TSI,
-			TOI:          0,
-			CloseObject:  true,
-			CloseSession: false,
-			CodePoint:    s.OTI.FECEncodingID,
-		},
-		OTI:             s.OTI,
-		SourceBlockNb:   0,
-		EncodingSymbol:  1,
-		TotalChunks:     1,
-		TransferLength:  uint64(len(payload)),
-		EncodingSymbols: payload,
-		ServerTime:      time.Now(),
-		FDT:             s.Fdt,
+	// FDT 不经过 FEC 编码，按 no-code 标记，避免接收端误用解码器
+	fdtOti, err := oti.NewNoCode(s.OTI.EncodingSymbolLength, 0).WithTransferLength(uint64(len(payload)))
+	if err != nil {
+		return err
 	}
 
-	packet := fdtAlcPkt.Serialize()
-	if _, err := s.Conn.Write(packet); err != nil {
-		return fmt.Errorf("send FDT packet failed: %w", err)
+	// FDT 在发送期间周期性重发，TOI 0 不设置 Close Object 标志
+	exts := []lct.Extension{lct.FDTExt{InstanceID: s.FdtInstance.InstanceID}}
+	if err := s.sendObject(0, bytes.NewReader(payload), fdtOti, fec.RepairOverhead{}, 0, false, exts, nil); err != nil {
+		return fmt.Errorf("send FDT instance %d failed: %w", s.FdtInstance.InstanceID, err)
 	}
+	fmt.Printf("FDT instance %d sent (%d files, %d bytes)\n", s.FdtInstance.InstanceID, len(s.FdtInstance.Files), len(payload))
 
 	return nil
 }
+
+// bufferPool 复用源块读取缓冲区，最多保留 size 个，发送大文件时内存占用与文件大小无关
+type bufferPool struct {
+	free chan []byte
+}
+
+func newBufferPool(size int) *bufferPool {
+	return &bufferPool{free: make(chan []byte, size)}
+}
+
+// get 返回长度为 n 的缓冲区，保留的缓冲区容量不足时重新分配
+func (p *bufferPool) get(n int) []byte {
+	select {
+	case buf := <-p.free:
+		if cap(buf) >= n {
+			return buf[:n]
+		}
+	default:
+	}
+	return make([]byte, n)
+}
+
+func (p *bufferPool) put(buf []byte) {
+	select {
+	case p.free <- buf:
+	default:
+	}
+}
+
+// sequentialReaderAt 将只能顺序读取的 io.Reader 适配为 io.ReaderAt，
+// 只支持从上次读取结束的位置继续读取（sendObject 按源块顺序读取）
+type sequentialReaderAt struct {
+	r   io.Reader
+	off int64
+}
+
+func (s *sequentialReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off != s.off {
+		return 0, fmt.Errorf("non-sequential read at offset %d, reader is at %d", off, s.off)
+	}
+	n, err := io.ReadFull(s.r, p)
+	s.off += int64(n)
+	return n, err
+}
@@ -1,7 +1,200 @@
 package udpendpoint
 
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// 组播默认 TTL（IPv6 为 Hop Limit），只在本网段内传播
+const defaultMulticastTTL = 1
+
 type Endpoint struct {
-	SourceAddr string
+	SourceAddr string // IPv4 或 IPv6 地址，链路本地地址可带区域索引（如 fe80::1%eth0）
 	DestAddr   string
 	Port       int
-}
\ No newline at end of file
+
+	// 以下参数仅在 DestAddr 为组播地址时生效
+	Interface string // 发送端的出接口 / 接收端加入组播组的接口，空时使用地址中的区域索引或由系统选择
+	TTL       int    // 组播 TTL / Hop Limit，0 表示使用默认值 1
+	Loopback  bool   // 是否将发出的组播包回送到本机
+
+	// 接收端的源地址（SSM）。非空时以 (S,G) 方式加入组播组（IGMPv3 / MLDv2），只接收这些源的数据
+	Sources []string
+}
+
+// ParseIP 解析 IPv4 或 IPv6 地址，IPv6 地址可带区域索引，返回 IP 与区域
+func ParseIP(s string) (net.IP, string, error) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid IP %q: %w", s, err)
+	}
+	return net.IP(addr.Unmap().AsSlice()), addr.Zone(), nil
+}
+
+// udpNetwork 返回与地址族对应的网络类型
+func udpNetwork(ip net.IP) string {
+	if ip.To4() != nil {
+		return "udp4"
+	}
+	return "udp6"
+}
+
+// IsMulticast 判断目的地址是否为组播地址
+func (e Endpoint) IsMulticast() bool {
+	ip, _, err := ParseIP(e.DestAddr)
+	return err == nil && ip.IsMulticast()
+}
+
+// needsZone 判断 IPv6 地址是否为链路本地或接口本地范围，这类地址须指定区域（接口）才能发送
+func needsZone(ip net.IP) bool {
+	return ip.To4() == nil && (ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast())
+}
+
+// multicastInterface 返回组播使用的接口：优先使用 Interface，其次使用地址中的区域索引
+func (e Endpoint) multicastInterface(zone string) (*net.Interface, error) {
+	name := e.Interface
+	if name == "" {
+		name = zone
+	}
+	if name == "" {
+		return nil, nil
+	}
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup interface %s: %w", name, err)
+	}
+	return ifi, nil
+}
+
+// Dial 创建发送端的 UDP 连接。目的地址为组播组时设置 TTL、回环与出接口
+func (e Endpoint) Dial() (*net.UDPConn, error) {
+	destIP, zone, err := ParseIP(e.DestAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination: %w", err)
+	}
+	if zone == "" && needsZone(destIP) {
+		zone = e.Interface
+	}
+	remoteAddr := &net.UDPAddr{IP: destIP, Port: e.Port, Zone: zone}
+
+	var localAddr *net.UDPAddr
+	if e.SourceAddr != "" {
+		ip, zone, err := ParseIP(e.SourceAddr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source: %w", err)
+		}
+		if (ip.To4() == nil) != (destIP.To4() == nil) {
+			return nil, fmt.Errorf("source %s and destination %s are of different address families", e.SourceAddr, e.DestAddr)
+		}
+		localAddr = &net.UDPAddr{IP: ip, Zone: zone}
+	}
+
+	conn, err := net.DialUDP(udpNetwork(destIP), localAddr, remoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("dial UDP %s: %w", remoteAddr, err)
+	}
+	if !destIP.IsMulticast() {
+		return conn, nil
+	}
+
+	ttl := e.TTL
+	if ttl <= 0 {
+		ttl = defaultMulticastTTL
+	}
+	if ttl > 255 {
+		conn.Close()
+		return nil, fmt.Errorf("invalid multicast TTL %d", e.TTL)
+	}
+	ifi, err := e.multicastInterface(zone)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if destIP.To4() == nil {
+		var ifIndex int
+		if ifi != nil {
+			ifIndex = ifi.Index
+		}
+		err = setMulticastOptions6(conn, ifIndex, ttl, e.Loopback)
+	} else {
+		var ifaceAddr net.IP
+		if ifi != nil {
+			if ifaceAddr, err = interfaceIPv4(ifi); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		err = setMulticastOptions(conn, ifaceAddr, ttl, e.Loopback)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("set multicast options: %w", err)
+	}
+	return conn, nil
+}
+
+// Listen 创建接收端的 UDP 连接。DestAddr 为组播组时在 Interface 指定的接口上加入该组，
+// 设置了 Sources 时按源加入（SSM）；否则监听 DestAddr（为空时监听所有 IPv4 与 IPv6 地址）
+func (e Endpoint) Listen() (*net.UDPConn, error) {
+	addr := &net.UDPAddr{Port: e.Port}
+	if e.DestAddr != "" {
+		ip, zone, err := ParseIP(e.DestAddr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listen address: %w", err)
+		}
+		addr.IP, addr.Zone = ip, zone
+	}
+	if addr.IP == nil || !addr.IP.IsMulticast() {
+		return net.ListenUDP("udp", addr)
+	}
+
+	ifi, err := e.multicastInterface(addr.Zone)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.Sources) > 0 {
+		sources, err := parseSources(e.Sources, addr.IP)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := listenSourceSpecific(udpNetwork(addr.IP), addr, ifi, sources)
+		if err != nil {
+			return nil, fmt.Errorf("join source-specific multicast group %s: %w", addr, err)
+		}
+		return conn, nil
+	}
+	conn, err := net.ListenMulticastUDP(udpNetwork(addr.IP), ifi, addr)
+	if err != nil {
+		return nil, fmt.Errorf("join multicast group %s: %w", addr, err)
+	}
+	return conn, nil
+}
+
+// parseSources 解析 SSM 源地址，源地址须与组地址同为 IPv4 或 IPv6 单播地址
+func parseSources(values []string, group net.IP) ([]net.IP, error) {
+	sources := make([]net.IP, 0, len(values))
+	for _, value := range values {
+		ip, _, err := ParseIP(value)
+		if err != nil || ip.IsMulticast() || (ip.To4() == nil) != (group.To4() == nil) {
+			return nil, fmt.Errorf("invalid multicast source %q for group %s", value, group)
+		}
+		sources = append(sources, ip)
+	}
+	return sources, nil
+}
+
+// interfaceIPv4 返回网络接口的第一个 IPv4 地址
+func interfaceIPv4(ifi *net.Interface) (net.IP, error) {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("list addresses of %s: %w", ifi.Name, err)
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return ipNet.IP.To4(), nil
+		}
+	}
+	return nil, fmt.Errorf("interface %s has no IPv4 address", ifi.Name)
+}
@@ -0,0 +1,17 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package udpendpoint
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+func setMulticastOptions(conn *net.UDPConn, ifaceAddr net.IP, ttl int, loopback bool) error {
+	return fmt.Errorf("multicast socket options are not supported on %s", runtime.GOOS)
+}
+
+func setMulticastOptions6(conn *net.UDPConn, ifIndex int, hops int, loopback bool) error {
+	return fmt.Errorf("multicast socket options are not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,69 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package udpendpoint
+
+import (
+	"net"
+	"syscall"
+)
+
+// setMulticastOptions 设置组播 TTL、回环与出接口。TTL 与回环按单字节设置，
+// Linux 同时接受 int 与 char，BSD 系统只接受 u_char
+func setMulticastOptions(conn *net.UDPConn, ifaceAddr net.IP, ttl int, loopback bool) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var loop byte
+	if loopback {
+		loop = 1
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if sockErr = syscall.SetsockoptByte(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, byte(ttl)); sockErr != nil {
+			return
+		}
+		if sockErr = syscall.SetsockoptByte(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, loop); sockErr != nil {
+			return
+		}
+		if ifaceAddr != nil {
+			var addr [4]byte
+			copy(addr[:], ifaceAddr.To4())
+			sockErr = syscall.SetsockoptInet4Addr(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, addr)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// setMulticastOptions6 设置 IPv6 组播 Hop Limit、回环与出接口（ifIndex 为 0 时由系统选择）
+func setMulticastOptions6(conn *net.UDPConn, ifIndex int, hops int, loopback bool) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	loop := 0
+	if loopback {
+		loop = 1
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, hops); sockErr != nil {
+			return
+		}
+		if sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_LOOP, loop); sockErr != nil {
+			return
+		}
+		if ifIndex > 0 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_IF, ifIndex)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
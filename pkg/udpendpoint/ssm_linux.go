@@ -0,0 +1,94 @@
+//go:build linux
+
+package udpendpoint
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// <linux/in.h> 中的 MCAST_JOIN_SOURCE_GROUP，IPv4（IGMPv3）与 IPv6（MLDv2）通用
+const mcastJoinSourceGroup = 46
+
+// sockaddr_storage 的长度
+const sockaddrStorageLen = 128
+
+// listenSourceSpecific 绑定组播组地址与端口，并对每个源地址以 (S,G) 方式加入组播组，
+// 内核只会交付来自这些源的组播包
+func listenSourceSpecific(network string, addr *net.UDPAddr, ifi *net.Interface, sources []net.IP) (*net.UDPConn, error) {
+	lc := net.ListenConfig{Control: func(_, _ string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}}
+	pc, err := lc.ListenPacket(context.Background(), network, addr.String())
+	if err != nil {
+		return nil, err
+	}
+	conn := pc.(*net.UDPConn)
+
+	level := syscall.IPPROTO_IP
+	if addr.IP.To4() == nil {
+		level = syscall.IPPROTO_IPV6
+	}
+	var ifIndex uint32
+	if ifi != nil {
+		ifIndex = uint32(ifi.Index)
+	}
+
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var joinErr error
+	err = raw.Control(func(fd uintptr) {
+		for _, source := range sources {
+			req := groupSourceReq(ifIndex, addr.IP, source)
+			if err := syscall.SetsockoptString(int(fd), level, mcastJoinSourceGroup, string(req)); err != nil {
+				joinErr = fmt.Errorf("join (%s, %s): %w", source, addr.IP, err)
+				return
+			}
+		}
+	})
+	if err == nil {
+		err = joinErr
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// groupSourceReq 按 struct group_source_req 的内存布局编码：gsr_interface 之后按 sockaddr_storage
+// 的对齐（unsigned long）填充，随后是组地址与源地址两个 sockaddr_storage
+func groupSourceReq(ifIndex uint32, group, source net.IP) []byte {
+	offset := int(unsafe.Alignof(uintptr(0)))
+	req := make([]byte, offset+2*sockaddrStorageLen)
+	binary.NativeEndian.PutUint32(req[0:4], ifIndex)
+	putSockaddr(req[offset:offset+sockaddrStorageLen], group)
+	putSockaddr(req[offset+sockaddrStorageLen:], source)
+	return req
+}
+
+// putSockaddr 写入端口为 0 的 sockaddr_in 或 sockaddr_in6
+func putSockaddr(b []byte, ip net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		binary.NativeEndian.PutUint16(b[0:2], syscall.AF_INET)
+		copy(b[4:8], ip4)
+		return
+	}
+	// sin6_family(2) | sin6_port(2) | sin6_flowinfo(4) | sin6_addr(16) | sin6_scope_id(4)
+	binary.NativeEndian.PutUint16(b[0:2], syscall.AF_INET6)
+	copy(b[8:24], ip.To16())
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package udpendpoint
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+func listenSourceSpecific(network string, addr *net.UDPAddr, ifi *net.Interface, sources []net.IP) (*net.UDPConn, error) {
+	return nil, fmt.Errorf("source-specific multicast is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// 来自网络的相对路径（FDT 中的 Content-Location）的长度上限
+const (
+	maxPathLength      = 1024
+	maxComponentLength = 255
+)
+
+// SanitizeRelativePath 校验并规范化来自网络的相对路径（以 "/" 分隔）。绝对路径、盘符、".."、
+// 控制字符、反斜杠以及过长的路径或路径分量都会被拒绝，空分量与 "." 被去除
+func SanitizeRelativePath(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("empty path")
+	}
+	if len(name) > maxPathLength {
+		return "", fmt.Errorf("path is %d bytes, limit is %d", len(name), maxPathLength)
+	}
+	if !utf8.ValidString(name) {
+		return "", fmt.Errorf("path %q is not valid UTF-8", name)
+	}
+	for _, r := range name {
+		switch {
+		case r < 0x20 || r == 0x7f || (r >= 0x80 && r < 0xa0):
+			return "", fmt.Errorf("path %q contains control character %U", name, r)
+		case r == '\\':
+			return "", fmt.Errorf("path %q contains a backslash", name)
+		case r == ':':
+			return "", fmt.Errorf("path %q contains a colon (drive letter or stream name)", name)
+		}
+	}
+	if strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("path %q is absolute", name)
+	}
+
+	parts := make([]string, 0, strings.Count(name, "/")+1)
+	for _, part := range strings.Split(name, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			return "", fmt.Errorf("path %q refers to a parent directory", name)
+		}
+		if len(part) > maxComponentLength {
+			return "", fmt.Errorf("path component %q is %d bytes, limit is %d", part, len(part), maxComponentLength)
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return "", fmt.Errorf("path %q has no file name", name)
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// ResolveInRoot 将经过 SanitizeRelativePath 的相对路径解析为 root 下的路径，并逐级创建中间目录。
+// 中间目录为符号链接时要求其目标仍在 root 内，最终路径不能是已存在的目录或符号链接
+func ResolveInRoot(root, rel string) (string, error) {
+	parts := strings.Split(rel, "/")
+	dir, err := resolveDir(root, parts[:len(parts)-1])
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, parts[len(parts)-1])
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("%s is a symlink", path)
+		}
+		if info.IsDir() {
+			return "", fmt.Errorf("%s is a directory", path)
+		}
+	}
+	return path, nil
+}
+
+// MkdirInRoot 在 root 下逐级创建经过 SanitizeRelativePath 的相对路径表示的目录，规则同 ResolveInRoot，
+// 返回目录的路径
+func MkdirInRoot(root, rel string) (string, error) {
+	return resolveDir(root, strings.Split(rel, "/"))
+}
+
+// resolveDir 从 root 开始逐级进入（必要时创建）parts 表示的目录，
+// 遇到符号链接时要求其目标仍在 root 内，返回最终目录解析符号链接后的路径
+func resolveDir(root string, parts []string) (string, error) {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("resolve storage root %s: %w", root, err)
+	}
+	if realRoot, err = filepath.Abs(realRoot); err != nil {
+		return "", err
+	}
+
+	dir := realRoot
+	for _, part := range parts {
+		next := filepath.Join(dir, part)
+		info, err := os.Lstat(next)
+		if os.IsNotExist(err) {
+			if err := os.Mkdir(next, 0o755); err != nil && !os.IsExist(err) {
+				return "", fmt.Errorf("create directory %s: %w", next, err)
+			}
+			info, err = os.Lstat(next)
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := filepath.EvalSymlinks(next)
+			if err != nil {
+				return "", fmt.Errorf("resolve symlink %s: %w", next, err)
+			}
+			if !isWithin(realRoot, target) {
+				return "", fmt.Errorf("%s is a symlink to %s outside %s", next, target, root)
+			}
+			next = target
+			if info, err = os.Stat(next); err != nil {
+				return "", err
+			}
+		}
+		if !info.IsDir() {
+			return "", fmt.Errorf("%s is not a directory", next)
+		}
+		dir = next
+	}
+	return dir, nil
+}
+
+// isWithin 判断 path 是否为 root 或位于 root 之下，两者均为已解析符号链接的绝对路径
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -2,8 +2,13 @@ package utils
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"hash"
+	"io"
+	"net/netip"
+	"os"
 	"os/exec"
 	"strings"
 )
@@ -13,16 +18,56 @@ func CalculateMD5(data []byte) string {
 	return hex.EncodeToString(hash[:])
 }
 
+// CalculateFileDigests 流式读取文件一遍，同时计算 MD5 与（withSHA256 为 true 时）SHA-256，
+// 不将整个文件读入内存。未计算的 SHA-256 返回空串
+func CalculateFileDigests(path string, withSHA256 bool) (md5sum, sha256sum string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	md5Hash := md5.New()
+	var w io.Writer = md5Hash
+	var sha256Hash hash.Hash
+	if withSHA256 {
+		sha256Hash = sha256.New()
+		w = io.MultiWriter(md5Hash, sha256Hash)
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		return "", "", err
+	}
+	md5sum = hex.EncodeToString(md5Hash.Sum(nil))
+	if sha256Hash != nil {
+		sha256sum = hex.EncodeToString(sha256Hash.Sum(nil))
+	}
+	return md5sum, sha256sum, nil
+}
+
+// EnsureStaticARP 为对端配置永久邻居表项：IPv4 为 ARP 表项，IPv6 为 NDP 表项。
+// 链路本地 IPv6 地址可带区域索引（如 fe80::1%eth0），iface 为空时使用该区域作为接口
 func EnsureStaticARP(enable bool, ip, mac, iface, role string) error {
 	if !enable {
 		fmt.Printf("Static ARP disabled for %s\n", role)
 		return nil
 	}
-	if ip == "" || mac == "" || iface == "" {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return fmt.Errorf("invalid ip %q for static neighbor entry: %w", ip, err)
+	}
+	addr = addr.Unmap()
+	if iface == "" {
+		iface = addr.Zone()
+	}
+	if mac == "" || iface == "" {
 		return fmt.Errorf("missing ip (%s), mac (%s) or iface (%s) for static ARP", ip, mac, iface)
 	}
 
-	cmd := exec.Command("ip", "neigh", "replace", ip, "lladdr", mac, "nud", "permanent", "dev", iface)
+	family := "-4"
+	if addr.Is6() {
+		family = "-6"
+	}
+	cmd := exec.Command("ip", family, "neigh", "replace", addr.WithZone("").String(), "lladdr", mac, "nud", "permanent", "dev", iface)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		trimmed := strings.TrimSpace(string(output))
@@ -32,6 +77,6 @@ func EnsureStaticARP(enable bool, ip, mac, iface, role string) error {
 		return fmt.Errorf("ip neigh replace failed: %v", err)
 	}
 
-	fmt.Printf("Static ARP configured for %s: %s -> %s via %s\n", role, ip, mac, iface)
+	fmt.Printf("Static neighbor entry configured for %s: %s -> %s via %s\n", role, addr.WithZone(""), mac, iface)
 	return nil
 }